@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// Regex to detect one-off payments among the Lohnarten
+var oneOffPaymentRegex = regexp.MustCompile(`(?i)einmal|sonderzahlung|urlaubsgeld|weihnachtsgeld|prämie|praemie|bonus|tantieme|abfindung|jubiläum`)
+
+// payslipFinding is an unusual change between a payslip and the months before it
+type payslipFinding struct {
+	Period      Period
+	Kind        string
+	Explanation string
+}
+
+// NewAnalyzeCmd creates and configures the analyze command
+func NewAnalyzeCmd(config Config) *cobra.Command {
+	var (
+		pdfPaths []string
+		walk     walkOptions
+		format   string
+		netDrop  float64
+		read     readFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "analyze",
+		Short: "Detect unusual changes between payslips",
+		Long: `Compare each payslip with the previous months and explain unusual changes:
+drops in net pay, new or disappearing Lohnarten, Steuerklasse changes,
+contribution rate changes such as the Zusatzbeitrag of the Krankenkasse, and
+one-off payments.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !isReportFormat(format) {
+				log.Error("Unsupported report format", "format", format, "supported", reportFormats)
+				os.Exit(1)
+			}
+
+			if err := checkWalkFlags(pdfPaths, walk); err != nil {
+				log.Error("Invalid PDF selection", "error", err)
+				os.Exit(1)
+			}
+
+			opts, err := read.readOptions(cmd, false)
+			if err != nil {
+				log.Error("Error setting up text extraction", "error", err)
+				os.Exit(1)
+			}
+
+			docs, err := loadDocuments(pdfPaths, walk, opts)
+			if err != nil {
+				log.Error("Error loading documents", "error", err)
+				os.Exit(1)
+			}
+
+			payslips := effectivePayslips(parsePayslips(docs))
+			findings := analyzePayslips(payslips, netDrop/100)
+
+			report := reportDoc{Title: "Auffälligkeiten"}
+			table := reportTable{Title: "Findings", Header: []string{"Month", "Finding", "Explanation"}}
+			for _, finding := range findings {
+				table.Rows = append(table.Rows, []string{finding.Period.String(), finding.Kind, finding.Explanation})
+			}
+			report.Tables = append(report.Tables, table)
+			if len(payslips) < 2 {
+				report.Notes = append(report.Notes, "At least two payslips are needed for a comparison.")
+			}
+
+			if err := renderReport(os.Stdout, format, report); err != nil {
+				log.Error("Error rendering report", "error", err)
+				os.Exit(1)
+			}
+
+			log.Info("Analyzed payslips", "count", len(payslips), "findings", len(findings))
+		},
+	}
+
+	addWalkFlags(cmd, config, &pdfPaths, &walk)
+	cmd.Flags().StringVar(&format, "format", "table", "Output format (table, markdown, html)")
+	cmd.Flags().Float64Var(&netDrop, "net-drop", 5, "Report net pay drops larger than this percentage")
+	addReadFlags(cmd, config, &read)
+
+	return cmd
+}
+
+// analyzePayslips compares each payslip with its predecessor and the history before it.
+// The payslips must be ordered by month, one per month.
+func analyzePayslips(payslips []Payslip, netDropThreshold float64) []payslipFinding {
+	var findings []payslipFinding
+	seenWageTypes := make(map[string]bool)
+
+	for i, current := range payslips {
+		period := current.EffectivePeriod()
+		add := func(kind, format string, args ...interface{}) {
+			findings = append(findings, payslipFinding{Period: period, Kind: kind, Explanation: fmt.Sprintf(format, args...)})
+		}
+
+		// One-off payments are reported regardless of history
+		for _, name := range sortedKeys(current.WageTypes) {
+			if oneOffPaymentRegex.MatchString(name) {
+				add("one-off payment", "%s of %s", name, current.WageTypes[name].German())
+			}
+		}
+
+		if i == 0 {
+			for name := range current.WageTypes {
+				seenWageTypes[name] = true
+			}
+			continue
+		}
+		previous := payslips[i-1]
+		previousPeriod := previous.EffectivePeriod()
+
+		// Net pay drops
+		if previous.Net > 0 && current.Net < previous.Net {
+			drop := float64(previous.Net-current.Net) / float64(previous.Net)
+			if drop > netDropThreshold {
+				add("net pay drop", "Net pay fell by %.1f %% from %s (%s) to %s",
+					drop*100, previous.Net.German(), previousPeriod, current.Net.German())
+			}
+		}
+
+		// New and disappearing Lohnarten
+		for _, name := range sortedKeys(current.WageTypes) {
+			if _, ok := previous.WageTypes[name]; ok || oneOffPaymentRegex.MatchString(name) {
+				continue
+			}
+			if seenWageTypes[name] {
+				add("returning Lohnart", "%s of %s is back after missing in %s", name, current.WageTypes[name].German(), previousPeriod)
+			} else {
+				add("new Lohnart", "%s of %s appears for the first time", name, current.WageTypes[name].German())
+			}
+		}
+		for _, name := range sortedKeys(previous.WageTypes) {
+			if _, ok := current.WageTypes[name]; !ok && !oneOffPaymentRegex.MatchString(name) {
+				add("missing Lohnart", "%s of %s in %s is missing", name, previous.WageTypes[name].German(), previousPeriod)
+			}
+		}
+		for name := range current.WageTypes {
+			seenWageTypes[name] = true
+		}
+
+		// Steuerklasse changes
+		if previous.TaxClass != "" && current.TaxClass != "" && previous.TaxClass != current.TaxClass {
+			add("Steuerklasse change", "Steuerklasse changed from %s to %s", previous.TaxClass, current.TaxClass)
+		}
+
+		// Contribution rate changes
+		for _, label := range sortedKeys(current.Rates) {
+			if before, ok := previous.Rates[label]; ok && before != current.Rates[label] {
+				add("rate change", "%s changed from %s %% to %s %%", label, before, current.Rates[label])
+			}
+		}
+	}
+
+	return findings
+}
+
+// sortedKeys returns the keys of a map in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
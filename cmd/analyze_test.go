@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func testAnalyzePayslip(month time.Month, net Money, wageTypes map[string]Money) Payslip {
+	return Payslip{
+		Document:  Document{Type: DocumentTypePayslip, Period: Period{2023, month}},
+		Net:       net,
+		WageTypes: wageTypes,
+	}
+}
+
+func TestAnalyzePayslips(t *testing.T) {
+	salary := map[string]Money{"1000 Gehalt": 500000}
+	withCar := map[string]Money{"1000 Gehalt": 500000, "2100 Dienstwagen": 40000}
+	withBonus := map[string]Money{"1000 Gehalt": 500000, "1500 Weihnachtsgeld": 250000}
+
+	taxClass := func(p Payslip, class string) Payslip {
+		p.TaxClass = class
+		return p
+	}
+	rate := func(p Payslip, value string) Payslip {
+		p.Rates = map[string]string{"Zusatzbeitrag": value}
+		return p
+	}
+	correction := func(p Payslip, month time.Month) Payslip {
+		p.Correction = &Period{2023, month}
+		p.CorrectionNumber = 1
+		return p
+	}
+
+	tests := []struct {
+		name     string
+		payslips []Payslip
+		// want lists the month and kind of each finding
+		want []string
+	}{
+		{"first month without history", []Payslip{
+			testAnalyzePayslip(time.January, 300000, withCar),
+		}, nil},
+		{"one-off payment in first month", []Payslip{
+			testAnalyzePayslip(time.January, 300000, withBonus),
+		}, []string{"2023-01 one-off payment"}},
+		{"one-off payment is neither new nor missing", []Payslip{
+			testAnalyzePayslip(time.November, 300000, salary),
+			testAnalyzePayslip(time.December, 300000, withBonus),
+			testAnalyzePayslip(time.January, 300000, salary),
+		}, []string{"2023-12 one-off payment"}},
+		{"net drop above threshold", []Payslip{
+			testAnalyzePayslip(time.January, 300000, salary),
+			testAnalyzePayslip(time.February, 270000, salary),
+		}, []string{"2023-02 net pay drop"}},
+		{"net drop at threshold", []Payslip{
+			testAnalyzePayslip(time.January, 300000, salary),
+			testAnalyzePayslip(time.February, 285000, salary),
+		}, nil},
+		{"zero net after pay", []Payslip{
+			testAnalyzePayslip(time.January, 300000, salary),
+			testAnalyzePayslip(time.February, 0, salary),
+		}, []string{"2023-02 net pay drop"}},
+		{"pay after zero net", []Payslip{
+			testAnalyzePayslip(time.January, 0, salary),
+			testAnalyzePayslip(time.February, 300000, salary),
+		}, nil},
+		{"new, missing and returning Lohnart", []Payslip{
+			testAnalyzePayslip(time.January, 300000, salary),
+			testAnalyzePayslip(time.February, 300000, withCar),
+			testAnalyzePayslip(time.March, 300000, salary),
+			testAnalyzePayslip(time.April, 300000, withCar),
+		}, []string{"2023-02 new Lohnart", "2023-03 missing Lohnart", "2023-04 returning Lohnart"}},
+		{"Steuerklasse change", []Payslip{
+			taxClass(testAnalyzePayslip(time.January, 300000, salary), "1"),
+			taxClass(testAnalyzePayslip(time.February, 300000, salary), ""),
+			taxClass(testAnalyzePayslip(time.March, 300000, salary), "1"),
+			taxClass(testAnalyzePayslip(time.April, 300000, salary), "3"),
+		}, []string{"2023-04 Steuerklasse change"}},
+		{"rate change", []Payslip{
+			rate(testAnalyzePayslip(time.January, 300000, salary), "1,30"),
+			rate(testAnalyzePayslip(time.February, 300000, salary), "1,30"),
+			rate(testAnalyzePayslip(time.March, 300000, salary), "1,70"),
+		}, []string{"2023-03 rate change"}},
+		{"Rückrechnung is reported for the corrected month", []Payslip{
+			testAnalyzePayslip(time.January, 300000, salary),
+			correction(testAnalyzePayslip(time.March, 250000, salary), time.February),
+		}, []string{"2023-02 net pay drop"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, finding := range analyzePayslips(tt.payslips, 0.05) {
+			got = append(got, finding.Period.Key()+" "+finding.Kind)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: findings %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
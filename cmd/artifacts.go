@@ -0,0 +1,372 @@
+package cmd
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	cdplog "github.com/chromedp/cdproto/log"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// redacted replaces credentials and cookies in debug artifacts
+const redacted = "[REDACTED]"
+
+// redactedHeaders lists the headers whose values are never written to debug artifacts
+var redactedHeaders = []string{"authorization", "cookie", "proxy-authorization", "set-cookie"}
+
+// debugRecorder records the browser's console and network activity so that
+// they can be written to a directory along with a screenshot and the DOM when
+// the download fails
+type debugRecorder struct {
+	dir     string
+	secrets []string
+
+	mu       sync.Mutex
+	console  []consoleEntry
+	requests map[network.RequestID]*harEntry
+	entries  []*harEntry
+}
+
+// consoleEntry is a console message, uncaught exception or browser log entry
+type consoleEntry struct {
+	Time   time.Time `json:"time"`
+	Source string    `json:"source"`
+	Level  string    `json:"level"`
+	Text   string    `json:"text"`
+	URL    string    `json:"url,omitempty"`
+}
+
+// harEntry is a request in the style of a HAR 1.2 entry, without content
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type harRequest struct {
+	Method   string       `json:"method"`
+	URL      string       `json:"url"`
+	Headers  []harHeader  `json:"headers"`
+	PostData *harPostData `json:"postData,omitempty"`
+}
+
+type harResponse struct {
+	Status     int64       `json:"status"`
+	StatusText string      `json:"statusText"`
+	Headers    []harHeader `json:"headers"`
+	MimeType   string      `json:"mimeType,omitempty"`
+}
+
+type harHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	Text string `json:"text"`
+}
+
+// newDebugRecorder returns a recorder writing artifacts to dir, or nil if dir is
+// empty. The secrets are redacted wherever they appear.
+func newDebugRecorder(dir string, secrets ...string) *debugRecorder {
+	if dir == "" {
+		return nil
+	}
+	r := &debugRecorder{dir: dir, requests: make(map[network.RequestID]*harEntry)}
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		// Form posts carry credentials URL-encoded
+		r.secrets = appendUnique(r.secrets, secret, url.QueryEscape(secret), url.PathEscape(secret))
+	}
+	return r
+}
+
+// listen starts recording the events of the browser tab of ctx
+func (r *debugRecorder) listen(ctx context.Context) {
+	chromedp.ListenTarget(ctx, func(ev any) {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		switch ev := ev.(type) {
+		case *runtime.EventConsoleAPICalled:
+			var args []string
+			for _, arg := range ev.Args {
+				args = append(args, remoteObjectText(arg))
+			}
+			r.console = append(r.console, consoleEntry{Time: time.Now(), Source: "console", Level: string(ev.Type), Text: strings.Join(args, " ")})
+		case *runtime.EventExceptionThrown:
+			text := ev.ExceptionDetails.Text
+			if ev.ExceptionDetails.Exception != nil {
+				text += " " + remoteObjectText(ev.ExceptionDetails.Exception)
+			}
+			r.console = append(r.console, consoleEntry{Time: time.Now(), Source: "exception", Level: "error", Text: text, URL: ev.ExceptionDetails.URL})
+		case *cdplog.EventEntryAdded:
+			r.console = append(r.console, consoleEntry{Time: time.Now(), Source: string(ev.Entry.Source), Level: string(ev.Entry.Level), Text: ev.Entry.Text, URL: ev.Entry.URL})
+		case *network.EventRequestWillBeSent:
+			// A redirect ends the previous request of the same id
+			if previous, ok := r.requests[ev.RequestID]; ok && ev.RedirectResponse != nil {
+				previous.Response = harResponseOf(ev.RedirectResponse)
+				previous.Time = float64(time.Since(previous.StartedDateTime).Milliseconds())
+			}
+			entry := &harEntry{
+				StartedDateTime: time.Now(),
+				Request: harRequest{
+					Method:  ev.Request.Method,
+					URL:     ev.Request.URL,
+					Headers: harHeaders(ev.Request.Headers),
+				},
+			}
+			if text := postDataText(ev.Request.PostDataEntries); text != "" {
+				entry.Request.PostData = &harPostData{Text: text}
+			}
+			r.requests[ev.RequestID] = entry
+			r.entries = append(r.entries, entry)
+		case *network.EventResponseReceived:
+			if entry, ok := r.requests[ev.RequestID]; ok {
+				entry.Response = harResponseOf(ev.Response)
+			}
+		case *network.EventLoadingFinished:
+			if entry, ok := r.requests[ev.RequestID]; ok {
+				entry.Time = float64(time.Since(entry.StartedDateTime).Milliseconds())
+			}
+		case *network.EventLoadingFailed:
+			if entry, ok := r.requests[ev.RequestID]; ok {
+				entry.Time = float64(time.Since(entry.StartedDateTime).Milliseconds())
+				entry.Error = ev.ErrorText
+			}
+		}
+	})
+}
+
+// capture writes a screenshot, the DOM, the console log and the network log of
+// the browser tab of ctx to a new subdirectory named after the current time.
+// ctx must not be the expired context of the failed operation. Artifacts that
+// can't be captured are skipped.
+func (r *debugRecorder) capture(ctx context.Context, cause error) {
+	dir := filepath.Join(r.dir, time.Now().Format("20060102-150405"))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Warn("Failed to create debug artifacts directory", "path", dir, "error", err)
+		return
+	}
+	log.Info("Capturing debug artifacts", "path", dir)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	write := func(name string, data []byte) {
+		if err := os.WriteFile(filepath.Join(dir, name), data, 0600); err != nil {
+			log.Warn("Failed to write debug artifact", "name", name, "error", err)
+		}
+	}
+
+	// Cookie values are redacted wherever they appear, not only in headers
+	var cookies []*network.Cookie
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) (err error) {
+		cookies, err = network.GetCookies().Do(ctx)
+		return err
+	})); err != nil {
+		log.Warn("Failed to get cookies for redaction", "error", err)
+	}
+	r.mu.Lock()
+	for _, cookie := range cookies {
+		if len(cookie.Value) >= 4 {
+			r.secrets = appendUnique(r.secrets, cookie.Value)
+		}
+	}
+	r.mu.Unlock()
+
+	write("error.txt", []byte(r.redact(cause.Error())+"\n"))
+
+	var pageURL string
+	var screenshot []byte
+	if err := chromedp.Run(ctx,
+		chromedp.Location(&pageURL),
+		// A quality of 100 takes a PNG
+		chromedp.FullScreenshot(&screenshot, 100),
+	); err != nil {
+		log.Warn("Failed to take screenshot", "error", err)
+	} else {
+		write("screenshot.png", screenshot)
+	}
+
+	var dom string
+	if err := chromedp.Run(ctx, chromedp.Evaluate(serializeDOMScript, &dom)); err != nil {
+		log.Warn("Failed to capture DOM", "error", err)
+	} else {
+		write("dom.html", []byte(r.redact(dom)))
+	}
+
+	r.mu.Lock()
+	console := make([]consoleEntry, len(r.console))
+	for i, entry := range r.console {
+		entry.Text = r.redact(entry.Text)
+		entry.URL = r.redact(entry.URL)
+		console[i] = entry
+	}
+	har := r.har(pageURL)
+	r.mu.Unlock()
+
+	if data, err := json.MarshalIndent(console, "", "  "); err == nil {
+		write("console.json", data)
+	}
+	if data, err := json.MarshalIndent(har, "", "  "); err == nil {
+		write("network.har", data)
+	}
+}
+
+// har returns the recorded requests as a redacted HAR log. r.mu must be held.
+func (r *debugRecorder) har(pageURL string) map[string]any {
+	entries := make([]harEntry, len(r.entries))
+	for i, entry := range r.entries {
+		e := *entry
+		e.Request.URL = r.redact(e.Request.URL)
+		e.Request.Headers = r.redactHeaders(e.Request.Headers)
+		if e.Request.PostData != nil {
+			e.Request.PostData = &harPostData{Text: r.redact(e.Request.PostData.Text)}
+		}
+		e.Response.Headers = r.redactHeaders(e.Response.Headers)
+		entries[i] = e
+	}
+	return map[string]any{
+		"log": map[string]any{
+			"version": "1.2",
+			"creator": map[string]string{"name": "adp", "version": "debug-artifacts"},
+			"pages":   []map[string]string{{"id": "page_1", "title": r.redact(pageURL)}},
+			"entries": entries,
+		},
+	}
+}
+
+// redact replaces the secrets in a text
+func (r *debugRecorder) redact(text string) string {
+	for _, secret := range r.secrets {
+		text = strings.ReplaceAll(text, secret, redacted)
+	}
+	return text
+}
+
+// redactHeaders redacts cookies and credentials in headers
+func (r *debugRecorder) redactHeaders(headers []harHeader) []harHeader {
+	result := make([]harHeader, len(headers))
+	for i, header := range headers {
+		header.Value = r.redact(header.Value)
+		for _, name := range redactedHeaders {
+			if strings.EqualFold(header.Name, name) {
+				header.Value = redacted
+			}
+		}
+		result[i] = header
+	}
+	return result
+}
+
+// harHeaders converts CDP headers into HAR headers sorted by name
+func harHeaders(headers network.Headers) []harHeader {
+	result := []harHeader{}
+	for name, value := range headers {
+		result = append(result, harHeader{Name: name, Value: fmt.Sprint(value)})
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Name < result[j].Name })
+	return result
+}
+
+func harResponseOf(response *network.Response) harResponse {
+	return harResponse{
+		Status:     response.Status,
+		StatusText: response.StatusText,
+		Headers:    harHeaders(response.Headers),
+		MimeType:   response.MimeType,
+	}
+}
+
+// postDataText decodes the base64-encoded body of a request
+func postDataText(entries []*network.PostDataEntry) string {
+	var text strings.Builder
+	for _, entry := range entries {
+		data, err := base64.StdEncoding.DecodeString(entry.Bytes)
+		if err != nil {
+			continue
+		}
+		text.Write(data)
+	}
+	return text.String()
+}
+
+// remoteObjectText returns a console argument as text
+func remoteObjectText(obj *runtime.RemoteObject) string {
+	if obj.Value != nil {
+		var s string
+		if err := json.Unmarshal(obj.Value, &s); err == nil {
+			return s
+		}
+		return string(obj.Value)
+	}
+	if obj.Description != "" {
+		return obj.Description
+	}
+	return string(obj.Type)
+}
+
+// serializeDOMScript returns the document as HTML, including open shadow roots
+// as declarative shadow DOM. Input values are properties rather than attributes
+// and are therefore not included.
+const serializeDOMScript = `
+	(function() {
+		const voidElements = new Set(['area', 'base', 'br', 'col', 'embed', 'hr', 'img', 'input', 'link', 'meta', 'source', 'track', 'wbr']);
+		const escapeText = (s) => s.replace(/&/g, '&amp;').replace(/</g, '&lt;').replace(/>/g, '&gt;');
+		const escapeAttr = (s) => escapeText(s).replace(/"/g, '&quot;');
+		function children(node) {
+			let html = '';
+			for (const child of node.childNodes) {
+				html += serialize(child);
+			}
+			return html;
+		}
+		function serialize(node) {
+			switch (node.nodeType) {
+			case Node.ELEMENT_NODE: {
+				const tag = node.localName;
+				let html = '<' + tag;
+				for (const attr of node.attributes) {
+					html += ' ' + attr.name + '="' + escapeAttr(attr.value) + '"';
+				}
+				html += '>';
+				if (node.shadowRoot) {
+					html += '<template shadowrootmode="' + node.shadowRoot.mode + '">' + children(node.shadowRoot) + '</template>';
+				}
+				if (voidElements.has(tag)) {
+					return html;
+				}
+				html += children(tag === 'template' ? node.content : node);
+				return html + '</' + tag + '>';
+			}
+			case Node.TEXT_NODE:
+				if (node.parentNode && ['script', 'style'].includes(node.parentNode.localName)) {
+					return node.textContent;
+				}
+				return escapeText(node.textContent);
+			case Node.COMMENT_NODE:
+				return '<!--' + node.textContent + '-->';
+			default:
+				return '';
+			}
+		}
+		return '<!DOCTYPE html>\n' + serialize(document.documentElement);
+	})()
+`
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// rulesVersion identifies the classification and field extraction rules.
+// Bump it whenever they change so that cached classifications are recomputed.
+const rulesVersion = 5
+
+// Cache stores extracted text and classification results on disk, keyed by the
+// SHA-256 of the PDF content
+type Cache struct {
+	dir string
+	// readOnly caches are never written to
+	readOnly bool
+}
+
+// cacheEntry is the cached result of extracting and classifying one PDF
+type cacheEntry struct {
+	Hash string `json:"hash"`
+	Text string `json:"text"`
+	// OCR is the engine that recognized the text of a scanned PDF, if any
+	OCR string `json:"ocr,omitempty"`
+	// RulesVersion is the rulesVersion the classification was made with
+	RulesVersion    int               `json:"rules_version"`
+	Type            DocumentType      `json:"type,omitempty"`
+	Locale          string            `json:"locale,omitempty"`
+	Period          string            `json:"period,omitempty"`
+	CorrectedPeriod string            `json:"corrected_period,omitempty"`
+	Fields          map[string]string `json:"fields,omitempty"`
+}
+
+// OpenCache opens or creates the cache in dir
+func OpenCache(dir string) (*Cache, error) {
+	// Entries hold the text of payslips, so only the user may read them
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %v", err)
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// OpenCacheReadOnly opens an existing cache without creating it or storing new entries
+func OpenCacheReadOnly(dir string) (*Cache, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return &Cache{dir: dir, readOnly: true}, nil
+}
+
+// path spreads the entries over subdirectories named after the first two hex digits
+func (c *Cache) path(hash string) string {
+	return filepath.Join(c.dir, hash[:2], hash+".json")
+}
+
+// Lookup returns the entry of a PDF by content hash
+func (c *Cache) Lookup(hash string) (cacheEntry, bool) {
+	var entry cacheEntry
+	if len(hash) < 2 {
+		return entry, false
+	}
+	data, err := os.ReadFile(c.path(hash))
+	if err != nil {
+		return entry, false
+	}
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Hash != hash {
+		return entry, false
+	}
+	return entry, true
+}
+
+// Store records the text and classification of a PDF, along with the OCR engine
+// that recognized the text, if any. Documents whose type or period wasn't
+// recognized are stored without type or period. A read-only cache is left
+// unchanged.
+func (c *Cache) Store(doc Document, fields map[string]string, ocr string) error {
+	if c.readOnly {
+		return nil
+	}
+	if len(doc.Hash) < 2 {
+		return fmt.Errorf("missing content hash for %s", doc.Path)
+	}
+
+	entry := cacheEntry{
+		Hash:         doc.Hash,
+		Text:         doc.Text,
+		OCR:          ocr,
+		RulesVersion: rulesVersion,
+		Type:         doc.Type,
+		Locale:       doc.Locale,
+		Fields:       fields,
+	}
+	if !doc.Period.IsZero() {
+		entry.Period = doc.Period.Key()
+	}
+	if doc.Correction != nil {
+		entry.CorrectedPeriod = doc.Correction.Key()
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode cache entry: %v", err)
+	}
+
+	// Write to a temporary file first so that concurrent readers never see a partial entry
+	path := c.path(doc.Hash)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Document rebuilds the cached classification. Like classifyDocument, it returns
+// errPeriodNotFound for documents of a known type without a period.
+func (e cacheEntry) Document(pdfPath string) (Document, error) {
+	if e.Type == DocumentTypeUnknown {
+		return Document{Path: pdfPath, Hash: e.Hash, Text: e.Text}, nil
+	}
+	if e.Period == "" {
+		return Document{Path: pdfPath, Hash: e.Hash, Type: e.Type, Text: e.Text, Locale: e.Locale}, errPeriodNotFound
+	}
+	doc, err := newDocument(pdfPath, e.Hash, e.Type, e.Period, e.CorrectedPeriod)
+	doc.Text = e.Text
+	doc.Locale = e.Locale
+	return doc, err
+}
@@ -0,0 +1,289 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// DocumentType identifies the kind of document found in the ADP archive
+type DocumentType string
+
+const (
+	DocumentTypeUnknown         DocumentType = ""
+	DocumentTypeTaxCertificate  DocumentType = "Lohnsteuerbescheinigung"
+	DocumentTypeSocialInsurance DocumentType = "Meldebescheinigung zur Sozialversicherung"
+	DocumentTypePayslip         DocumentType = "Verdienstabrechnung"
+)
+
+// errPeriodNotFound is returned when a document was recognized but its period could not be extracted
+var errPeriodNotFound = errors.New("couldn't extract month/year")
+
+// Period is a billing month, or a whole year when Month is zero
+type Period struct {
+	Year  int
+	Month time.Month
+}
+
+// String formats the period the way German ADP documents do, e.g. "März 2021"
+func (p Period) String() string {
+	if p.Month == 0 {
+		return strconv.Itoa(p.Year)
+	}
+	return fmt.Sprintf("%s %d", germanLocale.MonthNames[p.Month], p.Year)
+}
+
+// Key formats the period in a sortable form, e.g. "2021-03"
+func (p Period) Key() string {
+	if p.Month == 0 {
+		return fmt.Sprintf("%04d", p.Year)
+	}
+	return fmt.Sprintf("%04d-%02d", p.Year, p.Month)
+}
+
+// IsZero reports whether the period is unset
+func (p Period) IsZero() bool {
+	return p.Year == 0
+}
+
+// Before reports whether p lies before q
+func (p Period) Before(q Period) bool {
+	if p.Year != q.Year {
+		return p.Year < q.Year
+	}
+	return p.Month < q.Month
+}
+
+// Next returns the following month
+func (p Period) Next() Period {
+	if p.Month == time.December {
+		return Period{Year: p.Year + 1, Month: time.January}
+	}
+	return Period{Year: p.Year, Month: p.Month + 1}
+}
+
+// LastDay returns the last day of the period's month
+func (p Period) LastDay() time.Time {
+	return time.Date(p.Year, p.Month+1, 0, 0, 0, 0, 0, time.UTC)
+}
+
+// parsePeriod parses a month name and year as found in ADP documents of any locale
+func parsePeriod(monthName, year string) (Period, error) {
+	y, err := strconv.Atoi(year)
+	if err != nil {
+		return Period{}, fmt.Errorf("invalid year %q: %v", year, err)
+	}
+	for _, locale := range locales {
+		for month, name := range locale.MonthNames {
+			if strings.EqualFold(name, monthName) {
+				return Period{Year: y, Month: month}, nil
+			}
+		}
+	}
+	return Period{}, fmt.Errorf("unknown month %q", monthName)
+}
+
+// Document is a classified ADP document
+type Document struct {
+	// Path is the location of the PDF on disk
+	Path string
+	// Hash is the hex encoded SHA-256 of the file, if computed
+	Hash string
+	// Type is the detected document type
+	Type DocumentType
+	// Period is the Abrechnungsmonat, or the year for tax certificates
+	Period Period
+	// Correction is the month corrected by a Rückrechnung payslip
+	Correction *Period
+	// CorrectionNumber counts the Rückrechnungen of the corrected month, starting at 1
+	CorrectionNumber int
+	// Supersedes is the path of the version replaced by a Rückrechnung, if archived
+	Supersedes string
+	// Text is the text extracted from the PDF
+	Text string
+	// Locale is the Name of the locale the document was recognized in, if any
+	Locale string
+	// Decrypted is set if the text was read from a decrypted temporary copy
+	// of a password-protected PDF
+	Decrypted bool
+}
+
+// IsCorrection reports whether the document is a Rückrechnung payslip
+func (d Document) IsCorrection() bool {
+	return d.Correction != nil
+}
+
+// EffectivePeriod returns the month a payslip's amounts apply to
+func (d Document) EffectivePeriod() Period {
+	if d.Correction != nil {
+		return *d.Correction
+	}
+	return d.Period
+}
+
+// Filename returns the canonical filename for the document
+func (d Document) Filename() string {
+	switch d.Type {
+	case DocumentTypeTaxCertificate:
+		return fmt.Sprintf("Lohnsteuerbescheinigung - %d.pdf", d.Period.Year)
+	case DocumentTypePayslip:
+		if d.Correction != nil {
+			if d.CorrectionNumber > 1 {
+				return fmt.Sprintf("Verdienstabrechnung - %s - Rückrechnung %d.pdf", d.Correction, d.CorrectionNumber)
+			}
+			return fmt.Sprintf("Verdienstabrechnung - %s - Rückrechnung.pdf", d.Correction)
+		}
+		return fmt.Sprintf("Verdienstabrechnung - %s.pdf", d.Period)
+	default:
+		return fmt.Sprintf("%s - %s.pdf", d.Type, d.Period)
+	}
+}
+
+// classifyDocument detects the document type and period from the extracted text.
+// The candidate locales, or all locales if none are given, are tried in order
+// until one yields a type and period. If the type is recognized but the period
+// is not, the document is returned together with errPeriodNotFound.
+func classifyDocument(path, text string, candidates []Locale) (Document, error) {
+	if len(candidates) == 0 {
+		candidates = locales
+	}
+	doc := Document{Path: path, Text: text}
+	var recognized *Document
+	for _, locale := range candidates {
+		if !locale.matches(text) {
+			continue
+		}
+		classified, err := classifyDocumentLocale(doc, locale)
+		if err == nil {
+			return classified, nil
+		}
+		if recognized == nil {
+			recognized = &classified
+		}
+	}
+	if recognized != nil {
+		return *recognized, errPeriodNotFound
+	}
+	return doc, nil
+}
+
+// classifyDocumentLocale detects the document type and period using the texts of one locale
+func classifyDocumentLocale(doc Document, locale Locale) (Document, error) {
+	text := doc.Text
+	doc.Locale = locale.Name
+
+	// Check if it's a tax certificate
+	if matches := locale.TaxCertificate.FindStringSubmatch(text); len(matches) > 1 {
+		year, err := strconv.Atoi(matches[1])
+		if err != nil {
+			return doc, errPeriodNotFound
+		}
+		doc.Type = DocumentTypeTaxCertificate
+		doc.Period = Period{Year: year}
+		return doc, nil
+	}
+
+	switch {
+	case locale.SocialInsurance.MatchString(text):
+		doc.Type = DocumentTypeSocialInsurance
+	case locale.Payslip.MatchString(text):
+		doc.Type = DocumentTypePayslip
+	default:
+		return doc, nil
+	}
+
+	monthYearMatches := locale.Period.FindStringSubmatch(text)
+	if len(monthYearMatches) < 3 {
+		// The label and its value may end up far apart in the plain text
+		if layout := lazyLayout(doc)(); layout != nil {
+			monthYearMatches = layout.Value(locale.PeriodLabel, periodValueRegex)
+		}
+	}
+	if len(monthYearMatches) < 3 {
+		return doc, errPeriodNotFound
+	}
+	period, err := parsePeriod(monthYearMatches[1], monthYearMatches[2])
+	if err != nil {
+		return doc, errPeriodNotFound
+	}
+	doc.Period = period
+
+	// Check if it's a Rückrechnung
+	if doc.Type == DocumentTypePayslip {
+		if matches := locale.Correction.FindStringSubmatch(text); len(matches) > 2 {
+			corrected, err := parsePeriod(matches[1], matches[2])
+			if err != nil {
+				return doc, errPeriodNotFound
+			}
+			doc.Correction = &corrected
+		}
+	}
+
+	return doc, nil
+}
+
+// loadDocuments extracts and classifies all PDFs the walk options select in the
+// directories. Unreadable and unrecognized files are logged and skipped.
+func loadDocuments(pdfPaths []string, walk walkOptions, opts readOptions) ([]Document, error) {
+	docs, _, err := scanDocuments(pdfPaths, walk, opts)
+	return docs, err
+}
+
+// scanDocuments extracts and classifies all PDFs the walk options select in the
+// directories and additionally returns the paths of files that could not be classified.
+func scanDocuments(pdfPaths []string, walk walkOptions, opts readOptions) ([]Document, []string, error) {
+	pdfFiles, err := findPDFs(pdfPaths, walk)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list PDF files: %v", err)
+	}
+
+	var docs []Document
+	var unclassified []string
+	for _, found := range pdfFiles {
+		pdfFile := found.Path
+		filename := filepath.Base(pdfFile)
+
+		// The content hash keys the cache
+		hash, err := fileSHA256(pdfFile)
+		if err != nil {
+			log.Warn("Failed to hash PDF", "filename", filename, "error", err)
+		}
+
+		doc, _, err := readDocument(pdfFile, hash, opts)
+		if doc.Type == DocumentTypeUnknown && err != nil {
+			log.Warn("Failed to read PDF", "filename", filename, "error", err)
+			unclassified = append(unclassified, pdfFile)
+			continue
+		}
+		if doc.Type == DocumentTypeUnknown {
+			log.Debug("Not a recognized certificate type", "filename", filename)
+			unclassified = append(unclassified, pdfFile)
+			continue
+		}
+		if err != nil {
+			log.Warn("Skipping document", "filename", filename, "type", doc.Type, "error", err)
+			unclassified = append(unclassified, pdfFile)
+			continue
+		}
+		doc.CorrectionNumber = recordedCorrectionNumber(doc, nil)
+		docs = append(docs, doc)
+	}
+
+	linkCorrections(docs)
+
+	// Order by Abrechnungsmonat, with corrections after the regular documents they follow
+	sort.SliceStable(docs, func(i, j int) bool {
+		if docs[i].Period != docs[j].Period {
+			return docs[i].Period.Before(docs[j].Period)
+		}
+		return docs[i].CorrectionNumber < docs[j].CorrectionNumber
+	})
+
+	return docs, unclassified, nil
+}
@@ -0,0 +1,207 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// Regex to extract the Beschäftigungszeitraum from a social insurance certificate, e.g. "01.01.2020 - 31.12.2020"
+var employmentPeriodRegex = regexp.MustCompile(`(?:Beschäftigungszeit(?:raum)?|(?i:Employment period))\D*?(\d{2})\.(\d{2})\.(\d{4})\D+?(\d{2})\.(\d{2})\.(\d{4})`)
+
+// completenessResult lists the gaps found in the archive
+type completenessResult struct {
+	// From and To delimit the employment period derived from the documents
+	From, To       Period
+	MissingMonths  []Period
+	MissingYears   []int
+	Unclassified   []string
+	HasEmployments bool
+}
+
+// IsComplete reports whether no gaps were found
+func (r completenessResult) IsComplete() bool {
+	return len(r.MissingMonths) == 0 && len(r.MissingYears) == 0 && len(r.Unclassified) == 0
+}
+
+// NewVerifyCompletenessCmd creates and configures the verify completeness command
+func NewVerifyCompletenessCmd(config Config) *cobra.Command {
+	var (
+		pdfPaths []string
+		walk     walkOptions
+		format   string
+		read     readFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "completeness",
+		Short: "Find gaps in the document history",
+		Long: `Derive the employment period from the first and last payslip and the
+Beschäftigungszeitraum of the Meldebescheinigungen, then list months without a
+Verdienstabrechnung, years without a Lohnsteuerbescheinigung and files that could
+not be classified. Exits with status 1 if anything is missing.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !isReportFormat(format) {
+				log.Error("Unsupported report format", "format", format, "supported", reportFormats)
+				os.Exit(1)
+			}
+
+			if err := checkWalkFlags(pdfPaths, walk); err != nil {
+				log.Error("Invalid PDF selection", "error", err)
+				os.Exit(1)
+			}
+
+			opts, err := read.readOptions(cmd, false)
+			if err != nil {
+				log.Error("Error setting up text extraction", "error", err)
+				os.Exit(1)
+			}
+
+			docs, unclassified, err := scanDocuments(pdfPaths, walk, opts)
+			if err != nil {
+				log.Error("Error loading documents", "error", err)
+				os.Exit(1)
+			}
+
+			result := checkCompleteness(docs, unclassified, time.Now())
+			if err := renderReport(os.Stdout, format, completenessReport(result)); err != nil {
+				log.Error("Error rendering report", "error", err)
+				os.Exit(1)
+			}
+
+			if !result.IsComplete() {
+				log.Error("Document history is incomplete",
+					"missing_months", len(result.MissingMonths),
+					"missing_years", len(result.MissingYears),
+					"unclassified", len(result.Unclassified))
+				os.Exit(1)
+			}
+
+			log.Info("Document history is complete", "from", result.From, "to", result.To)
+		},
+	}
+
+	addWalkFlags(cmd, config, &pdfPaths, &walk)
+	cmd.Flags().StringVar(&format, "format", "table", "Output format (table, markdown, html)")
+	addReadFlags(cmd, config, &read)
+
+	return cmd
+}
+
+// parseEmploymentPeriod extracts the Beschäftigungszeitraum from a social insurance certificate
+func parseEmploymentPeriod(doc Document) (from, to Period, ok bool) {
+	matches := employmentPeriodRegex.FindStringSubmatch(doc.Text)
+	if len(matches) < 7 {
+		return Period{}, Period{}, false
+	}
+
+	var numbers [6]int
+	for i := range numbers {
+		n, err := strconv.Atoi(matches[i+1])
+		if err != nil {
+			return Period{}, Period{}, false
+		}
+		numbers[i] = n
+	}
+
+	// Reject misread months, e.g. 13 from OCR, which would never be reached
+	// while counting months
+	if numbers[1] < 1 || numbers[1] > 12 || numbers[4] < 1 || numbers[4] > 12 {
+		return Period{}, Period{}, false
+	}
+
+	from = Period{Year: numbers[2], Month: time.Month(numbers[1])}
+	to = Period{Year: numbers[5], Month: time.Month(numbers[4])}
+	return from, to, true
+}
+
+// checkCompleteness finds months and years without documents within the employment period.
+// Tax certificates are only expected once they are due, i.e. from March of the following year.
+func checkCompleteness(docs []Document, unclassified []string, now time.Time) completenessResult {
+	result := completenessResult{Unclassified: unclassified}
+
+	extend := func(from, to Period) {
+		if result.From.IsZero() || from.Before(result.From) {
+			result.From = from
+		}
+		if result.To.IsZero() || result.To.Before(to) {
+			result.To = to
+		}
+	}
+
+	payslipMonths := make(map[Period]bool)
+	certificateYears := make(map[int]bool)
+	for _, doc := range docs {
+		switch doc.Type {
+		case DocumentTypePayslip:
+			period := doc.EffectivePeriod()
+			payslipMonths[period] = true
+			extend(period, period)
+		case DocumentTypeTaxCertificate:
+			certificateYears[doc.Period.Year] = true
+		case DocumentTypeSocialInsurance:
+			if from, to, ok := parseEmploymentPeriod(doc); ok {
+				result.HasEmployments = true
+				extend(from, to)
+			}
+		}
+	}
+
+	if result.From.IsZero() {
+		return result
+	}
+
+	for period := result.From; !result.To.Before(period); period = period.Next() {
+		if !payslipMonths[period] {
+			result.MissingMonths = append(result.MissingMonths, period)
+		}
+	}
+
+	for year := result.From.Year; year <= result.To.Year; year++ {
+		due := time.Date(year+1, time.March, 1, 0, 0, 0, 0, time.Local)
+		if !certificateYears[year] && !now.Before(due) {
+			result.MissingYears = append(result.MissingYears, year)
+		}
+	}
+
+	return result
+}
+
+func completenessReport(result completenessResult) reportDoc {
+	report := reportDoc{Title: "Vollständigkeit"}
+
+	if result.From.IsZero() {
+		report.Notes = append(report.Notes, "No payslips or Meldebescheinigungen with a Beschäftigungszeitraum found.")
+	} else {
+		report.Notes = append(report.Notes, fmt.Sprintf("Employment period: %s to %s.", result.From, result.To))
+	}
+	if !result.HasEmployments {
+		report.Notes = append(report.Notes, "No Beschäftigungszeitraum found; the period is derived from the payslips only.")
+	}
+
+	months := reportTable{Title: "Months without Verdienstabrechnung", Header: []string{"Month"}}
+	for _, period := range result.MissingMonths {
+		months.Rows = append(months.Rows, []string{period.String()})
+	}
+	report.Tables = append(report.Tables, months)
+
+	years := reportTable{Title: "Years without Lohnsteuerbescheinigung", Header: []string{"Year"}}
+	for _, year := range result.MissingYears {
+		years.Rows = append(years.Rows, []string{strconv.Itoa(year)})
+	}
+	report.Tables = append(report.Tables, years)
+
+	files := reportTable{Title: "Unclassified files", Header: []string{"File"}}
+	for _, path := range result.Unclassified {
+		files.Rows = append(files.Rows, []string{filepath.Base(path)})
+	}
+	report.Tables = append(report.Tables, files)
+
+	return report
+}
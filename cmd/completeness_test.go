@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestCheckCompleteness(t *testing.T) {
+	payslip := func(year int, month time.Month) Document {
+		return Document{Type: DocumentTypePayslip, Period: Period{year, month}}
+	}
+	certificate := Document{Type: DocumentTypeTaxCertificate, Period: Period{2023, time.December}}
+	misread := Document{Type: DocumentTypeSocialInsurance, Period: Period{2024, time.December},
+		Text: "Beschäftigungszeitraum 01.13.2024 - 31.12.2024"}
+
+	tests := []struct {
+		name          string
+		docs          []Document
+		now           time.Time
+		missingMonths []Period
+		missingYears  []int
+	}{
+		{"gap", []Document{payslip(2024, time.January), payslip(2024, time.March)},
+			time.Date(2024, time.June, 1, 0, 0, 0, 0, time.Local), []Period{{2024, time.February}}, nil},
+		{"certificate not yet due", []Document{payslip(2023, time.December)},
+			time.Date(2024, time.February, 29, 0, 0, 0, 0, time.Local), nil, nil},
+		{"certificate due in March", []Document{payslip(2023, time.December)},
+			time.Date(2024, time.March, 1, 0, 0, 0, 0, time.Local), nil, []int{2023}},
+		{"certificate present", []Document{payslip(2023, time.December), certificate},
+			time.Date(2024, time.March, 1, 0, 0, 0, 0, time.Local), nil, nil},
+		{"misread month", []Document{payslip(2024, time.January), misread},
+			time.Date(2024, time.June, 1, 0, 0, 0, 0, time.Local), nil, nil},
+	}
+	for _, tt := range tests {
+		result := checkCompleteness(tt.docs, nil, tt.now)
+		if !reflect.DeepEqual(result.MissingMonths, tt.missingMonths) {
+			t.Errorf("%s: missing months %v, want %v", tt.name, result.MissingMonths, tt.missingMonths)
+		}
+		if !reflect.DeepEqual(result.MissingYears, tt.missingYears) {
+			t.Errorf("%s: missing years %v, want %v", tt.name, result.MissingYears, tt.missingYears)
+		}
+	}
+}
+
+func TestParseEmploymentPeriodRejectsInvalidMonths(t *testing.T) {
+	for _, text := range []string{
+		"Beschäftigungszeitraum 01.13.2024 - 31.12.2024",
+		"Beschäftigungszeitraum 01.01.2024 - 31.00.2024",
+	} {
+		if from, to, ok := parseEmploymentPeriod(Document{Text: text}); ok {
+			t.Errorf("parseEmploymentPeriod(%q) = %v, %v, want no period", text, from, to)
+		}
+	}
+	from, to, ok := parseEmploymentPeriod(Document{Text: "Beschäftigungszeitraum 01.04.2023 - 31.12.2023"})
+	if !ok || from != (Period{2023, time.April}) || to != (Period{2023, time.December}) {
+		t.Errorf("parseEmploymentPeriod = %v, %v, %v, want April to December 2023", from, to, ok)
+	}
+}
@@ -12,6 +12,8 @@ import (
 type Config struct {
 	// Default paths
 	DefaultDir string
+	// DataDir holds the tool's own state, such as the document index
+	DataDir string
 }
 
 // NewConfig initializes shared configuration values
@@ -24,5 +26,6 @@ func NewConfig() Config {
 
 	return Config{
 		DefaultDir: filepath.Join(home, "Downloads", "adpworld.adp.com"),
+		DataDir:    filepath.Join(home, ".adp"),
 	}
 }
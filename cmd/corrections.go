@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/charmbracelet/log"
+)
+
+// linkCorrections groups payslips by the month they apply to, numbers successive
+// Rückrechnungen in order of their Abrechnungsmonat and links each to the version it
+// supersedes. Rückrechnungen of the same Abrechnungsmonat keep the order of the
+// numbers they were given before, see recordedCorrectionNumber, followed by new
+// ones in order of their content hash, so that renaming them doesn't change the
+// order. The documents are updated in place.
+func linkCorrections(docs []Document) {
+	byPeriod := make(map[Period][]int)
+	for i, doc := range docs {
+		if doc.Type == DocumentTypePayslip {
+			period := doc.EffectivePeriod()
+			byPeriod[period] = append(byPeriod[period], i)
+		}
+	}
+
+	for _, indices := range byPeriod {
+		// Regular payslip first, then corrections by issue month
+		sort.SliceStable(indices, func(a, b int) bool {
+			x, y := docs[indices[a]], docs[indices[b]]
+			if x.IsCorrection() != y.IsCorrection() {
+				return !x.IsCorrection()
+			}
+			if x.Period != y.Period {
+				return x.Period.Before(y.Period)
+			}
+			if (x.CorrectionNumber > 0) != (y.CorrectionNumber > 0) {
+				return x.CorrectionNumber > 0
+			}
+			if x.CorrectionNumber != y.CorrectionNumber {
+				return x.CorrectionNumber < y.CorrectionNumber
+			}
+			if x.Hash != y.Hash {
+				return x.Hash < y.Hash
+			}
+			return x.Path < y.Path
+		})
+
+		number := 0
+		supersedes := ""
+		for _, i := range indices {
+			if docs[i].IsCorrection() {
+				number++
+				docs[i].CorrectionNumber = number
+				docs[i].Supersedes = supersedes
+			}
+			supersedes = docs[i].Path
+		}
+	}
+}
+
+// recordedCorrectionNumber returns the number an earlier run gave a Rückrechnung,
+// as recorded in its sidecar or the index, if set. Returns 0 if none is known.
+func recordedCorrectionNumber(doc Document, index *Index) int {
+	if doc.Correction == nil || doc.Hash == "" {
+		return 0
+	}
+	if sidecar, _, ok := readSidecar(doc.Path); ok && sidecar.Hash == doc.Hash && sidecar.CorrectedPeriod == doc.Correction.Key() {
+		return sidecar.CorrectionNumber
+	}
+	if index != nil {
+		entry, ok, err := index.Lookup(doc.Hash)
+		if err != nil {
+			log.Warn("Failed to look up document in index", "filename", filepath.Base(doc.Path), "error", err)
+		} else if ok && entry.CorrectedPeriod == doc.Correction.Key() {
+			return entry.CorrectionNumber
+		}
+	}
+	return 0
+}
@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ledongthuc/pdf"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// decryptedModes lists where decrypted copies of password-protected PDFs are
+// stored: next to the original, or in place of it
+var decryptedModes = []string{"alongside", "replace"}
+
+// decryptedSuffix replaces the extension of decrypted copies stored alongside
+// the original. Such copies are skipped when looking for PDFs.
+const decryptedSuffix = ".decrypted.pdf"
+
+// isDecryptedCopy reports whether a file is the decrypted copy of another PDF
+func isDecryptedCopy(path string) bool {
+	return strings.HasSuffix(strings.ToLower(path), decryptedSuffix)
+}
+
+// pdfPasswordsEnv holds comma-separated document passwords, e.g. birth dates
+// or personnel numbers
+const pdfPasswordsEnv = "ADP_PDF_PASSWORDS"
+
+// pdfPasswordsFile is the name of the file in the data directory holding one
+// document password per line
+const pdfPasswordsFile = "pdf-passwords"
+
+func isDecryptedMode(mode string) bool {
+	for _, m := range decryptedModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// envPDFPasswords returns the document passwords from the environment
+func envPDFPasswords() []string {
+	var passwords []string
+	for _, password := range strings.Split(os.Getenv(pdfPasswordsEnv), ",") {
+		if password != "" {
+			passwords = append(passwords, password)
+		}
+	}
+	return passwords
+}
+
+// readPasswordsFile returns the document passwords stored in a file, one per
+// line. A missing file holds no passwords. Like SSH keys, the file must not be
+// accessible by other users.
+func readPasswordsFile(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return nil, fmt.Errorf("%s is accessible by other users, restrict it with chmod 600", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var passwords []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if password := strings.TrimRight(line, "\r"); password != "" {
+			passwords = append(passwords, password)
+		}
+	}
+	return passwords, nil
+}
+
+// decryptedCopyPath returns the location of the decrypted copy stored alongside a PDF
+func decryptedCopyPath(path string) string {
+	return strings.TrimSuffix(path, filepath.Ext(path)) + decryptedSuffix
+}
+
+// decryptedSource returns the decrypted copy stored alongside a PDF if there is
+// one, and the PDF itself otherwise
+func decryptedSource(path string) string {
+	if copyPath := decryptedCopyPath(path); copyPath != path {
+		if _, err := os.Stat(copyPath); err == nil {
+			return copyPath
+		}
+	}
+	return path
+}
+
+// isEncryptedPDF reports whether a PDF can't be opened without a password
+func isEncryptedPDF(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	// The PDF library only reads some encryption schemes; others are reported as unsupported
+	_, err = pdf.NewReader(f, info.Size())
+	if err == nil {
+		return false, nil
+	}
+	return errors.Is(err, pdf.ErrInvalidPassword) || strings.Contains(err.Error(), "encryption"), nil
+}
+
+// decryptPDF returns the content of a password-protected PDF without encryption,
+// trying each password as user and owner password. The empty password is tried
+// first, as some PDFs only restrict permissions.
+func decryptPDF(path string, passwords []string) ([]byte, error) {
+	// Don't create a pdfcpu configuration directory in the user's home
+	api.DisableConfigDir()
+
+	for _, password := range append([]string{""}, passwords...) {
+		conf := model.NewDefaultConfiguration()
+		conf.ValidationMode = model.ValidationRelaxed
+		conf.UserPW = password
+		conf.OwnerPW = password
+
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		err = api.Decrypt(f, &buf, conf)
+		f.Close()
+		if errors.Is(err, pdfcpu.ErrWrongPassword) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt PDF: %v", err)
+		}
+		return buf.Bytes(), nil
+	}
+	if len(passwords) == 0 {
+		return nil, fmt.Errorf("encrypted PDF: no password given (--pdf-password, %s or --pdf-passwords-file)", pdfPasswordsEnv)
+	}
+	return nil, fmt.Errorf("encrypted PDF: none of the %d passwords matched", len(passwords))
+}
+
+// readablePDF returns a path the text of a PDF can be extracted from: the PDF
+// itself, or a decrypted temporary copy if it is password-protected. The
+// returned function removes the copy.
+func readablePDF(path string, passwords []string) (string, func(), error) {
+	noop := func() {}
+	if encrypted, err := isEncryptedPDF(path); err != nil || !encrypted {
+		// Other errors are reported by the text extraction
+		return path, noop, nil
+	}
+
+	data, err := decryptPDF(path, passwords)
+	if err != nil {
+		return "", noop, err
+	}
+	tmp, err := os.CreateTemp("", "adp-decrypted-*.pdf")
+	if err != nil {
+		return "", noop, err
+	}
+	cleanup := func() { os.Remove(tmp.Name()) }
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		cleanup()
+		return "", noop, err
+	}
+	if err := tmp.Close(); err != nil {
+		cleanup()
+		return "", noop, err
+	}
+	return tmp.Name(), cleanup, nil
+}
+
+// storeDecrypted writes the decrypted content of a password-protected PDF next
+// to it or over it, depending on mode. Before replacing the PDF, the encrypted
+// original is copied to backup, so that undo can restore it. Returns whether
+// the PDF was encrypted and the path written.
+func storeDecrypted(path, mode string, passwords []string, backup string) (bool, string, error) {
+	encrypted, err := isEncryptedPDF(path)
+	if err != nil || !encrypted {
+		return false, "", err
+	}
+
+	target := path
+	if mode == "alongside" {
+		target = decryptedCopyPath(path)
+		if _, err := os.Stat(target); err == nil {
+			return true, "", nil
+		}
+	}
+
+	data, err := decryptPDF(path, passwords)
+	if err != nil {
+		return true, "", err
+	}
+
+	if mode == "replace" {
+		if err := os.MkdirAll(filepath.Dir(backup), 0700); err != nil {
+			return true, "", fmt.Errorf("failed to create backup directory: %v", err)
+		}
+		if err := copyFile(path, backup); err != nil {
+			return true, "", fmt.Errorf("failed to back up encrypted PDF: %v", err)
+		}
+	}
+
+	// Write to a temporary file first so that the original is never left half-written
+	tmp, err := os.CreateTemp(filepath.Dir(target), filepath.Base(target)+".*.tmp")
+	if err != nil {
+		return true, "", err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return true, "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return true, "", err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		os.Remove(tmp.Name())
+		return true, "", err
+	}
+	return true, target, nil
+}
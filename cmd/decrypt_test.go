@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// writeEncryptedPDF writes a test PDF protected with a user password
+func writeEncryptedPDF(t *testing.T, path, password string) {
+	t.Helper()
+	api.DisableConfigDir()
+	var buf bytes.Buffer
+	conf := model.NewAESConfiguration(password, password, 256)
+	if err := api.Encrypt(bytes.NewReader(testPDF()), &buf, conf); err != nil {
+		t.Fatalf("encrypting test PDF: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReadPasswordsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, pdfPasswordsFile)
+
+	if passwords, err := readPasswordsFile(path); err != nil || passwords != nil {
+		t.Fatalf("readPasswordsFile on missing file = %v, %v, want no passwords", passwords, err)
+	}
+
+	if err := os.WriteFile(path, []byte("01.02.1980\r\n\n12345\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	passwords, err := readPasswordsFile(path)
+	if err != nil {
+		t.Fatalf("readPasswordsFile: %v", err)
+	}
+	if want := []string{"01.02.1980", "12345"}; !reflect.DeepEqual(passwords, want) {
+		t.Errorf("readPasswordsFile = %v, want %v", passwords, want)
+	}
+
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readPasswordsFile(path); err == nil {
+		t.Error("readPasswordsFile accepted a file readable by other users")
+	}
+}
+
+func TestReadDocumentDoesNotCacheDecryptedText(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "protected.pdf")
+	writeEncryptedPDF(t, path, "01.02.1980")
+	hash, err := fileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := OpenCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := readDocument(path, hash, readOptions{Cache: cache}); err == nil {
+		t.Error("readDocument read a protected PDF without password")
+	}
+
+	opts := readOptions{Cache: cache, Passwords: []string{"01.02.1980"}}
+	doc, _, err := readDocument(path, hash, opts)
+	if err != nil {
+		t.Fatalf("readDocument: %v", err)
+	}
+	if !doc.Decrypted {
+		t.Error("document read from a decrypted copy isn't marked as decrypted")
+	}
+	if _, ok := cache.Lookup(hash); ok {
+		t.Error("decrypted text was cached")
+	}
+
+	opts.CacheDecrypted = true
+	if _, _, err := readDocument(path, hash, opts); err != nil {
+		t.Fatalf("readDocument: %v", err)
+	}
+	if _, ok := cache.Lookup(hash); !ok {
+		t.Error("decrypted text wasn't cached with CacheDecrypted")
+	}
+}
+
+func TestStoreDecryptedReplaceKeepsBackup(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "protected.pdf")
+	writeEncryptedPDF(t, path, "secret")
+	original, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	backup := filepath.Join(dir, "backup", "original.pdf")
+
+	encrypted, written, err := storeDecrypted(path, "replace", []string{"secret"}, backup)
+	if err != nil {
+		t.Fatalf("storeDecrypted: %v", err)
+	}
+	if !encrypted || written != path {
+		t.Fatalf("storeDecrypted = %v, %q, want the PDF replaced", encrypted, written)
+	}
+	if still, err := isEncryptedPDF(path); err != nil || still {
+		t.Errorf("PDF is still encrypted after replacing: %v", err)
+	}
+
+	saved, err := os.ReadFile(backup)
+	if err != nil {
+		t.Fatalf("reading backup: %v", err)
+	}
+	if !bytes.Equal(saved, original) {
+		t.Error("backup differs from the encrypted original")
+	}
+
+	// Undo puts the encrypted original back
+	if err := restoreBackup(backup, path); err != nil {
+		t.Fatalf("restoreBackup: %v", err)
+	}
+	restored, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(restored, original) {
+		t.Error("restored PDF differs from the encrypted original")
+	}
+	if _, err := os.Stat(backup); !os.IsNotExist(err) {
+		t.Error("backup wasn't removed after restoring it")
+	}
+}
@@ -0,0 +1,326 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/chromedp"
+	"github.com/spf13/cobra"
+)
+
+// Statuses of doctor checks
+const (
+	checkOK   = "OK"
+	checkWarn = "WARN"
+	checkFail = "FAIL"
+	checkSkip = "SKIP"
+)
+
+// doctorCheck is the outcome of one check
+type doctorCheck struct {
+	Name   string
+	Status string
+	Detail string
+}
+
+// NewDoctorCmd creates and configures the doctor command
+func NewDoctorCmd(config Config) *cobra.Command {
+	var (
+		portal  portalFlags
+		browser browserOptions
+		timeout int
+		login   bool
+		format  string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the setup for downloading",
+		Long: `Check that Chrome starts, the download path and data directory are
+writable, and the index, selector profile, locale and credentials are usable.
+The checks create nothing: missing directories are checked through their
+nearest existing parent. With --login, create the directories and walk the
+login flow step by step, reporting the first step that fails.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !isReportFormat(format) {
+				log.Error("Unsupported report format", "format", format, "supported", reportFormats)
+				os.Exit(1)
+			}
+
+			var checks []doctorCheck
+			checks = append(checks, checkBrowser(browser))
+			if browser.UserDataDir != "" && browser.RemoteURL == "" {
+				checks = append(checks, checkWritableDir("User data directory", browser.UserDataDir, login))
+			}
+			checks = append(checks, checkWritableDir("Download path", portal.downloadPath, login))
+			checks = append(checks, checkWritableDir("Data directory", config.DataDir, login))
+			checks = append(checks, checkIndex(portal.indexPath))
+
+			portalLocales, err := selectLocales(portal.localeName)
+			if err != nil {
+				checks = append(checks, doctorCheck{"Locale", checkFail, err.Error()})
+			} else {
+				checks = append(checks, doctorCheck{"Locale", checkOK, portal.localeName})
+			}
+
+			override, err := readSelectorProfile(portal.selectorPath)
+			selectors := selectorChain(override)
+			switch {
+			case err != nil:
+				checks = append(checks, doctorCheck{"Selector profile", checkFail, err.Error()})
+			case override == nil:
+				checks = append(checks, doctorCheck{"Selector profile", checkOK, "built-in: " + selectors.Version})
+			default:
+				checks = append(checks, doctorCheck{"Selector profile", checkOK, selectors.Version})
+			}
+
+			// A running browser or kept profile may be logged in already
+			optional := browser.RemoteURL != "" || browser.UserDataDir != ""
+			checks = append(checks, checkCredential("Username", portal.username, "ADP_USERNAME", "--username", optional))
+			checks = append(checks, checkCredential("Password", portal.password, "ADP_PASSWORD", "--password", optional))
+
+			if login {
+				if hasFailure(checks) {
+					checks = append(checks, doctorCheck{"Login", checkSkip, "fix the failed checks first"})
+				} else {
+					checks = append(checks, checkLogin(portal.siteURL, portal.username, portal.password, browser, time.Duration(timeout)*time.Minute, portalLocales, selectors, portal.debugDir)...)
+				}
+			}
+
+			report := reportDoc{
+				Title:  "ADP doctor",
+				Tables: []reportTable{doctorTable(checks)},
+			}
+			if err := renderReport(os.Stdout, format, report); err != nil {
+				log.Error("Error rendering report", "error", err)
+				os.Exit(1)
+			}
+			if hasFailure(checks) {
+				os.Exit(1)
+			}
+		},
+	}
+
+	addPortalFlags(cmd, config, &portal)
+	addBrowserFlags(cmd, &browser)
+	cmd.Flags().IntVar(&timeout, "timeout", 5, "Timeout in minutes for the login flow")
+	cmd.Flags().BoolVar(&login, "login", false, "Walk the login flow against --url up to the document list")
+	cmd.Flags().StringVar(&format, "format", "table", "Output format (table, markdown, html)")
+
+	return cmd
+}
+
+// doctorTable lists the checks as a report table
+func doctorTable(checks []doctorCheck) reportTable {
+	table := reportTable{
+		Title:  "Checks",
+		Header: []string{"Check", "Status", "Detail"},
+	}
+	for _, check := range checks {
+		table.Rows = append(table.Rows, []string{check.Name, check.Status, check.Detail})
+	}
+	return table
+}
+
+func hasFailure(checks []doctorCheck) bool {
+	for _, check := range checks {
+		if check.Status == checkFail {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBrowser connects to the running browser, or starts the browser the way
+// download does, and asks it for its version. A started browser gets a
+// temporary profile, so that the check leaves --user-data-dir untouched.
+func checkBrowser(opts browserOptions) doctorCheck {
+	source := opts.RemoteURL
+	if opts.RemoteURL == "" {
+		path, err := chromeExecPath(opts.ChromePath)
+		if err != nil {
+			return doctorCheck{"Chrome", checkFail, "not found: " + err.Error()}
+		}
+		// Start exactly the binary that is reported
+		opts.ChromePath = path
+		source = path
+	}
+	opts.UserDataDir = ""
+	opts.Headless = true
+
+	ctx, cancel := newBrowser(opts, nil)
+	defer cancel()
+	ctx, cancel = context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var product string
+	if err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) (err error) {
+		_, product, _, _, _, err = browser.GetVersion().Do(ctx)
+		return err
+	})); err != nil {
+		if opts.RemoteURL != "" {
+			return doctorCheck{"Chrome", checkFail, fmt.Sprintf("failed to connect to %s: %v", opts.RemoteURL, err)}
+		}
+		return doctorCheck{"Chrome", checkFail, fmt.Sprintf("failed to start Chrome (%s): %v", source, err)}
+	}
+	return doctorCheck{"Chrome", checkOK, fmt.Sprintf("%s (%s)", product, source)}
+}
+
+// checkWritableDir reports whether files can be written to the directory,
+// creating it if create is set. Otherwise a missing directory is checked
+// through its nearest existing parent. The probe file is removed either way.
+func checkWritableDir(name, dir string, create bool) doctorCheck {
+	existing := dir
+	if create {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return doctorCheck{name, checkFail, err.Error()}
+		}
+	} else {
+		var err error
+		if existing, err = nearestExistingDir(dir); err != nil {
+			return doctorCheck{name, checkFail, err.Error()}
+		}
+	}
+
+	f, err := os.CreateTemp(existing, ".adp-doctor-*")
+	if err != nil {
+		return doctorCheck{name, checkFail, fmt.Sprintf("%s is not writable: %v", existing, err)}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	if existing != dir {
+		return doctorCheck{name, checkOK, fmt.Sprintf("%s (to be created in %s)", dir, existing)}
+	}
+	return doctorCheck{name, checkOK, dir}
+}
+
+// nearestExistingDir returns the directory itself if it exists, or else its
+// nearest existing parent
+func nearestExistingDir(dir string) (string, error) {
+	dir = filepath.Clean(dir)
+	for {
+		info, err := os.Stat(dir)
+		switch {
+		case err == nil && info.IsDir():
+			return dir, nil
+		case err == nil:
+			return "", fmt.Errorf("%s is not a directory", dir)
+		case !os.IsNotExist(err):
+			return "", err
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no parent of %s exists", dir)
+		}
+		dir = parent
+	}
+}
+
+// checkIndex opens the document index read-only. A missing index is created
+// and an outdated one upgraded by the next download.
+func checkIndex(path string) doctorCheck {
+	if path == "" {
+		return doctorCheck{"Index", checkSkip, "disabled"}
+	}
+	index, err := OpenIndexReadOnly(path)
+	switch {
+	case errors.Is(err, errNoIndex):
+		return doctorCheck{"Index", checkOK, path + " (to be created)"}
+	case errors.Is(err, errIndexOutdated):
+		return doctorCheck{"Index", checkWarn, err.Error()}
+	case err != nil:
+		return doctorCheck{"Index", checkFail, err.Error()}
+	}
+	index.Close()
+	return doctorCheck{"Index", checkOK, path}
+}
+
+// checkCredential reports whether a credential is given, without revealing it.
+// A missing optional credential is only a warning.
+func checkCredential(name, value, env, flag string, optional bool) doctorCheck {
+	if value == "" {
+		status := checkFail
+		if optional {
+			status = checkWarn
+		}
+		return doctorCheck{name, status, fmt.Sprintf("set %s or use %s", env, flag)}
+	}
+	source := flag
+	if value == os.Getenv(env) {
+		source = env
+	}
+	return doctorCheck{name, checkOK, "given by " + source}
+}
+
+// checkLogin walks the login flow step by step. The steps after the first
+// failing one are skipped.
+func checkLogin(siteURL, username, password string, browser browserOptions, timeout time.Duration, portalLocales []Locale, selectors SelectorProfile, debugDir string) []doctorCheck {
+	var checks []doctorCheck
+
+	// Tell an unreachable site from a changed one
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(siteURL)
+	if err != nil {
+		return []doctorCheck{{"Reach site", checkFail, err.Error()}}
+	}
+	resp.Body.Close()
+	status := checkOK
+	if resp.StatusCode >= 400 {
+		// The browser may still get through, e.g. past bot protection
+		status = checkWarn
+	}
+	checks = append(checks, doctorCheck{"Reach site", status, fmt.Sprintf("%s: %s", siteURL, resp.Status)})
+
+	browserCtx, cancel := newBrowser(browser, portalLocales)
+	defer cancel()
+	recorder := newDebugRecorder(debugDir, username, password)
+	if recorder != nil {
+		recorder.listen(browserCtx)
+	}
+	ctx, cancel := context.WithTimeout(browserCtx, timeout)
+	defer cancel()
+
+	var session portalSession
+	var links []string
+	steps := loginSteps(siteURL, username, password, portalLocales, selectors, &session)
+	steps = append(steps, portalStep{"Find document list", func(ctx context.Context) (err error) {
+		links, err = findPDFLinks(ctx, session.Locale, selectors)
+		return err
+	}})
+
+	failed := false
+	for _, step := range steps {
+		if failed {
+			checks = append(checks, doctorCheck{step.Name, checkSkip, ""})
+			continue
+		}
+		log.Info("Checking login step", "step", step.Name)
+		start := time.Now()
+		if err := step.Run(ctx); err != nil {
+			checks = append(checks, doctorCheck{step.Name, checkFail, err.Error()})
+			failed = true
+			if recorder != nil {
+				recorder.capture(browserCtx, err)
+			}
+			continue
+		}
+		detail := time.Since(start).Round(time.Millisecond).String()
+		switch {
+		case session.LoggedIn && step.Name == "Find username field":
+			detail = "already logged in, " + detail
+		case step.Name == "Wait for dashboard":
+			detail = fmt.Sprintf("locale %s, %s", session.Locale.Name, detail)
+		case step.Name == "Find document list":
+			detail = fmt.Sprintf("%d documents, %s", len(links), detail)
+		}
+		checks = append(checks, doctorCheck{step.Name, checkOK, detail})
+	}
+	return checks
+}
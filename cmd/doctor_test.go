@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDoctorChecksCreateNothing(t *testing.T) {
+	root := t.TempDir()
+	dir := filepath.Join(root, "adp", "downloads")
+
+	check := checkWritableDir("Download path", dir, false)
+	if check.Status != checkOK {
+		t.Errorf("checkWritableDir = %+v, want OK", check)
+	}
+	if index := checkIndex(filepath.Join(root, "adp", "index.db")); index.Status != checkOK {
+		t.Errorf("checkIndex = %+v, want OK", index)
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("checks left %v behind", entries)
+	}
+
+	// With --login the directory is created
+	if check := checkWritableDir("Download path", dir, true); check.Status != checkOK {
+		t.Errorf("checkWritableDir = %+v, want OK", check)
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("checkWritableDir didn't create the directory: %v", err)
+	}
+}
+
+func TestCheckBrowserReportsMissingChrome(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PATH", dir)
+
+	check := checkBrowser(browserOptions{ChromePath: filepath.Join(dir, "chrome")})
+	if check.Status != checkFail || !strings.HasPrefix(check.Detail, "not found") {
+		t.Errorf("checkBrowser with a missing --chrome-path = %+v, want not found", check)
+	}
+
+	// Without --chrome-path the binary is looked up like chromedp does
+	path := filepath.Join(dir, "chromium")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "darwin" && runtime.GOOS != "windows" {
+		if found, err := chromeExecPath(""); err != nil || found != path {
+			t.Errorf("chromeExecPath = %q, %v, want %q", found, err, path)
+		}
+	}
+}
@@ -2,14 +2,19 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
@@ -23,12 +28,9 @@ import (
 // NewDownloadCmd creates and configures the download command
 func NewDownloadCmd(config Config) *cobra.Command {
 	var (
-		siteURL      string
-		username     string
-		password     string
-		downloadPath string
-		headless     bool
-		timeout      int
+		portal  portalFlags
+		browser browserOptions
+		timeout int
 	)
 
 	cmd := &cobra.Command{
@@ -36,19 +38,50 @@ func NewDownloadCmd(config Config) *cobra.Command {
 		Short: "Download PDFs from ADP",
 		Long:  `Download all PDFs from adpworld.adp.com after logging in with provided credentials.`,
 		Run: func(cmd *cobra.Command, args []string) {
+			// Credentials are required unless a running browser or kept profile may be logged in already
+			if (portal.username == "" || portal.password == "") && browser.RemoteURL == "" && browser.UserDataDir == "" {
+				log.Error("Missing credentials: set ADP_USERNAME and ADP_PASSWORD or use --username and --password")
+				os.Exit(1)
+			}
+
 			// Create download directory if it doesn't exist
-			if err := os.MkdirAll(downloadPath, 0755); err != nil {
+			if err := os.MkdirAll(portal.downloadPath, 0755); err != nil {
 				log.Error("Failed to create download directory", "error", err)
 				os.Exit(1)
 			}
 
 			log.Info("Starting ADP PDF downloader",
-				"url", siteURL,
-				"download_path", downloadPath,
+				"url", portal.siteURL,
+				"download_path", portal.downloadPath,
 				"timeout_minutes", timeout)
 
+			portalLocales, err := selectLocales(portal.localeName)
+			if err != nil {
+				log.Error("Invalid locale", "error", err)
+				os.Exit(1)
+			}
+
+			// Built-in selectors, preceded by the overrides of the selector profile
+			override, err := readSelectorProfile(portal.selectorPath)
+			if err != nil {
+				log.Error("Error reading selector profile", "error", err)
+				os.Exit(1)
+			}
+			selectors := selectorChain(override)
+			log.Debug("Using selector profiles", "versions", selectors.Version)
+
+			// Open the document index unless disabled
+			var index *Index
+			if portal.indexPath != "" {
+				if index, err = OpenIndex(portal.indexPath); err != nil {
+					log.Error("Error opening index", "error", err)
+					os.Exit(1)
+				}
+				defer index.Close()
+			}
+
 			// Run the downloader
-			if err := downloadPDFs(siteURL, username, password, downloadPath, headless, timeout); err != nil {
+			if err := downloadPDFs(portal.siteURL, portal.username, portal.password, portal.downloadPath, browser, timeout, index, portalLocales, selectors, portal.debugDir); err != nil {
 				log.Error("Error downloading PDFs", "error", err)
 				os.Exit(1)
 			}
@@ -58,267 +91,458 @@ func NewDownloadCmd(config Config) *cobra.Command {
 	}
 
 	// Add flags specific to the download command
-	cmd.Flags().StringVar(&siteURL, "url", "https://adpworld.adp.com", "ADP website URL")
-	cmd.Flags().StringVarP(&username, "username", "u", os.Getenv("ADP_USERNAME"), "ADP username (required if ADP_USERNAME env var not set)")
-	cmd.Flags().StringVarP(&password, "password", "p", os.Getenv("ADP_PASSWORD"), "ADP password (required if ADP_PASSWORD env var not set)")
-	cmd.Flags().BoolVar(&headless, "headless", true, "Run browser in headless mode (no UI)")
-	cmd.Flags().StringVar(&downloadPath, "download-path", config.DefaultDir, "Path to download PDFs")
+	addPortalFlags(cmd, config, &portal)
+	addBrowserFlags(cmd, &browser)
 	cmd.Flags().IntVar(&timeout, "timeout", 15, "Timeout in minutes for the entire operation")
 
-	// Mark flags as required only if environment variables are not set
-	if os.Getenv("ADP_USERNAME") == "" {
-		cmd.MarkFlagRequired("username")
-	}
-	if os.Getenv("ADP_PASSWORD") == "" {
-		cmd.MarkFlagRequired("password")
-	}
-
 	return cmd
 }
 
-// waitForElement waits for an element to be visible with custom timeout and polling
-func waitForElement(ctx context.Context, selector string, timeout time.Duration) error {
-	log.Debug("Waiting for element", "selector", selector, "timeout", timeout)
+// portalFlags holds the command line flags of the commands logging in to the portal
+type portalFlags struct {
+	siteURL      string
+	username     string
+	password     string
+	downloadPath string
+	indexPath    string
+	selectorPath string
+	localeName   string
+	debugDir     string
+}
 
-	// Create a context with timeout
-	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+// addPortalFlags adds the flags locating the portal, the credentials and where
+// downloads go to a command
+func addPortalFlags(cmd *cobra.Command, config Config, flags *portalFlags) {
+	cmd.Flags().StringVar(&flags.siteURL, "url", "https://adpworld.adp.com", "ADP website URL")
+	cmd.Flags().StringVarP(&flags.username, "username", "u", os.Getenv("ADP_USERNAME"), "ADP username (required if ADP_USERNAME env var not set, unless the browser is logged in)")
+	cmd.Flags().StringVarP(&flags.password, "password", "p", os.Getenv("ADP_PASSWORD"), "ADP password (required if ADP_PASSWORD env var not set, unless the browser is logged in)")
+	cmd.Flags().StringVar(&flags.downloadPath, "download-path", config.DefaultDir, "Path to download PDFs")
+	cmd.Flags().StringVar(&flags.indexPath, "index", filepath.Join(config.DataDir, "index.db"), "Path to the document index (empty to disable)")
+	cmd.Flags().StringVar(&flags.selectorPath, "selectors", filepath.Join(config.DataDir, "selectors.yaml"), "YAML selector profile tried before the built-in selectors, if it exists")
+	cmd.Flags().StringVar(&flags.localeName, "locale", "auto", "Language the portal is displayed in (auto, de, en)")
+	cmd.Flags().StringVar(&flags.debugDir, "debug-artifacts", "", "Directory to write a screenshot, the DOM, console and network logs to on failure")
+}
+
+// addBrowserFlags adds the flags selecting the browser to a command
+func addBrowserFlags(cmd *cobra.Command, opts *browserOptions) {
+	cmd.Flags().BoolVar(&opts.Headless, "headless", true, "Run browser in headless mode (no UI)")
+	cmd.Flags().StringVar(&opts.RemoteURL, "browser-url", "", "DevTools URL of a running browser to use instead of starting one, e.g. ws://localhost:9222")
+	cmd.Flags().StringVar(&opts.ChromePath, "chrome-path", "", "Chrome or Chromium binary to start (default: found on PATH)")
+	cmd.Flags().StringVar(&opts.UserDataDir, "user-data-dir", "", "Browser profile directory to keep logins in (default: temporary incognito profile)")
+}
 
-	// Start polling
-	start := time.Now()
-	for {
-		// Check if context is done
-		select {
-		case <-timeoutCtx.Done():
-			return fmt.Errorf("timed out after %v waiting for element: %s", timeout, selector)
-		default:
-			// Continue
+// fillField types a value into a login form field. ADP's custom elements keep
+// their input in a shadow root; plain inputs are filled directly.
+func fillField(selector, value string) chromedp.Action {
+	return chromedp.Evaluate(`
+		(function() {
+			const field = document.querySelector(`+jsString(selector)+`);
+			if (!field) {
+				return false;
+			}
+			const input = field.shadowRoot ? field.shadowRoot.querySelector("#input") : field;
+			if (input) {
+				input.focus();
+				input.value = `+jsString(value)+`;
+				input.dispatchEvent(new Event('input', { bubbles: true }));
+				input.dispatchEvent(new Event('change', { bubbles: true }));
+				return true;
+			}
+			return false;
+		})()
+	`, nil)
+}
+
+// clickableElements selects the elements clickByText looks at
+const clickableElements = `button, a, [role="button"]`
+
+// clickByText clicks the first button or link whose text matches the pattern.
+// The texts are matched in Go rather than with a JavaScript RegExp, so that
+// patterns behave as validated by regexp.Compile.
+func clickByText(pattern *regexp.Regexp) chromedp.Action {
+	return chromedp.ActionFunc(func(ctx context.Context) error {
+		var texts []string
+		if err := chromedp.Evaluate(`Array.from(document.querySelectorAll(`+jsString(clickableElements)+`), el => el.textContent)`, &texts).Do(ctx); err != nil {
+			return fmt.Errorf("failed to read buttons: %v", err)
+		}
+		i := slices.IndexFunc(texts, pattern.MatchString)
+		if i < 0 {
+			return fmt.Errorf("no button matches %q", pattern)
 		}
 
-		// Check if element is visible
-		var visible bool
-		err := chromedp.Run(timeoutCtx, chromedp.Evaluate(`
+		// Click the element only if the page didn't change in the meantime
+		var clicked bool
+		if err := chromedp.Evaluate(`
 			(function() {
-				const el = document.querySelector(`+"`"+selector+"`"+`);
-				return el !== null && 
-					(el.offsetWidth > 0 || el.offsetHeight > 0 || el.getClientRects().length > 0);
+				const el = document.querySelectorAll(`+jsString(clickableElements)+`)[`+strconv.Itoa(i)+`];
+				if (!el || el.textContent !== `+jsString(texts[i])+`) {
+					return false;
+				}
+				el.click();
+				return true;
 			})()
-		`, &visible))
-
-		// If evaluation failed, wait and retry
-		if err != nil {
-			if strings.Contains(err.Error(), "context deadline exceeded") {
-				return fmt.Errorf("timed out waiting for element: %s", selector)
-			}
-			time.Sleep(500 * time.Millisecond)
-			continue
+		`, &clicked).Do(ctx); err != nil {
+			return fmt.Errorf("failed to click button: %v", err)
 		}
-
-		// If element is visible, return success
-		if visible {
-			elapsed := time.Since(start)
-			log.Debug("Element found", "selector", selector, "elapsed", elapsed)
-			return nil
+		if !clicked {
+			return fmt.Errorf("button matching %q changed before it was clicked", pattern)
 		}
+		return nil
+	})
+}
 
-		// Wait before next check
-		time.Sleep(500 * time.Millisecond)
-	}
+// jsString quotes a string as a JavaScript string literal
+func jsString(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
 }
 
-// waitForText waits for text matching a regex pattern to appear on the page
-func waitForText(ctx context.Context, pattern string, timeout time.Duration) error {
-	log.Debug("Waiting for text matching pattern", "pattern", pattern, "timeout", timeout)
+// waitForDashboard waits for the button leading to the document list and returns
+// the locale the portal is displayed in and the pattern matching the button text
+func waitForDashboard(ctx context.Context, portalLocales []Locale, selectors SelectorProfile, timeout time.Duration) (Locale, string, error) {
+	patterns := dashboardPatterns(portalLocales, selectors)
+	if err := waitForText(ctx, "(?:"+strings.Join(patterns, "|")+")", timeout); err != nil {
+		return Locale{}, "", err
+	}
 
-	// Create a context with timeout
-	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
+	var pageText string
+	if err := chromedp.Run(ctx, chromedp.Evaluate(`document.body.innerText`, &pageText)); err != nil {
+		return Locale{}, "", fmt.Errorf("failed to read page text: %v", err)
+	}
 
-	// Compile the regex
-	regex, err := regexp.Compile(pattern)
-	if err != nil {
-		return fmt.Errorf("invalid regex pattern: %v", err)
-	}
-
-	// Start polling
-	start := time.Now()
-	for {
-		// Check if context is done
-		select {
-		case <-timeoutCtx.Done():
-			return fmt.Errorf("timed out after %v waiting for text matching: %s", timeout, pattern)
-		default:
-			// Continue
+	// Patterns of the selector profile don't tell the language
+	locale := portalLocales[0]
+	for _, l := range portalLocales {
+		if regexp.MustCompile(l.AllDocumentsPattern()).MatchString(pageText) {
+			locale = l
+			break
 		}
+	}
+	for _, pattern := range patterns {
+		if regexp.MustCompile(pattern).MatchString(pageText) {
+			return locale, pattern, nil
+		}
+	}
+	return Locale{}, "", fmt.Errorf("dashboard changed while detecting its language")
+}
 
-		// Get the page text content
-		var pageText string
-		err := chromedp.Run(timeoutCtx, chromedp.Evaluate(`
-			(function() {
-				return document.body.innerText;
-			})()
-		`, &pageText))
+// dashboardPatterns returns the patterns matching the text of the dashboard
+// button leading to the document list
+func dashboardPatterns(portalLocales []Locale, selectors SelectorProfile) []string {
+	patterns := append([]string{}, selectors.AllDocuments...)
+	for _, locale := range portalLocales {
+		patterns = append(patterns, locale.AllDocumentsPattern())
+	}
+	return patterns
+}
 
-		// If evaluation failed, wait and retry
-		if err != nil {
-			if strings.Contains(err.Error(), "context deadline exceeded") {
-				return fmt.Errorf("timed out waiting for text matching: %s", pattern)
-			}
-			time.Sleep(500 * time.Millisecond)
-			continue
+// waitForLoginOrDashboard waits for the username field and returns the selector
+// matching it, or for the dashboard of a browser that is already logged in and
+// returns an empty selector
+func waitForLoginOrDashboard(ctx context.Context, portalLocales []Locale, selectors SelectorProfile, timeout time.Duration) (string, error) {
+	if len(selectors.Username) == 0 {
+		return "", fmt.Errorf("no selectors given")
+	}
+	selectorsJSON, err := json.Marshal(selectors.Username)
+	if err != nil {
+		return "", err
+	}
+	dashboard, err := regexp.Compile("(?:" + strings.Join(dashboardPatterns(portalLocales, selectors), "|") + ")")
+	if err != nil {
+		return "", err
+	}
+
+	var selector string
+	err = waitForDOM(ctx, timeout, "any element of: "+strings.Join(selectors.Username, " | ")+" or the dashboard", `{
+		found: `+visibleElementScript+`(`+string(selectorsJSON)+`),
+		text: document.body ? document.body.innerText : ""
+	}`, func(value json.RawMessage) (bool, error) {
+		var state struct {
+			Found int    `json:"found"`
+			Text  string `json:"text"`
+		}
+		if err := json.Unmarshal(value, &state); err != nil {
+			return false, err
+		}
+		if state.Found >= 0 {
+			selector = selectors.Username[state.Found]
+			return true, nil
 		}
+		return dashboard.MatchString(state.Text), nil
+	})
+	return selector, err
+}
 
-		// Check if the text matches the pattern
-		if regex.MatchString(pageText) {
-			elapsed := time.Since(start)
-			log.Debug("Text matching pattern found", "pattern", pattern, "elapsed", elapsed)
-			return nil
+// browserOptions selects the browser the portal is automated in
+type browserOptions struct {
+	// RemoteURL is the DevTools endpoint of a running browser to open a tab in
+	// instead of starting one, e.g. ws://localhost:9222
+	RemoteURL string
+	// ChromePath is the browser binary to start instead of the one found on PATH
+	ChromePath string
+	// UserDataDir is the profile directory to start the browser with. Without
+	// it, the browser starts in incognito mode with a temporary profile.
+	UserDataDir string
+	Headless    bool
+}
+
+// newBrowser starts a Chrome instance requesting the language of the portal
+// locales, or opens a tab in the running browser at opts.RemoteURL. The tab's
+// requests are tracked from the start for runUntilNetworkIdle. Cancelling the
+// returned context stops the browser or closes the tab.
+func newBrowser(opts browserOptions, portalLocales []Locale) (context.Context, context.CancelFunc) {
+	logf := chromedp.WithLogf(func(format string, args ...interface{}) {
+		log.Debug(fmt.Sprintf(format, args...), "source", "chromedp")
+	})
+
+	// A running browser keeps its own language and profile; its tab shares the
+	// cookies of its default profile, so the portal may already be logged in
+	if opts.RemoteURL != "" {
+		if opts.ChromePath != "" || opts.UserDataDir != "" {
+			log.Warn("Ignoring --chrome-path and --user-data-dir for a running browser", "browser_url", opts.RemoteURL)
+		}
+		allocCtx, cancelAlloc := chromedp.NewRemoteAllocator(context.Background(), opts.RemoteURL)
+		ctx, cancelTab := chromedp.NewContext(allocCtx, logf)
+		return trackNetwork(ctx), func() {
+			cancelTab()
+			cancelAlloc()
 		}
+	}
 
-		// Wait before next check
-		time.Sleep(500 * time.Millisecond)
+	// Request the language of the selected locale, German if it is detected
+	language := germanLocale.Language
+	if len(portalLocales) == 1 {
+		language = portalLocales[0].Language
 	}
-}
 
-func downloadPDFs(siteURL, username, password, downloadPath string, headless bool, timeoutMinutes int) error {
-	// Create a new Chrome instance with incognito mode
-	opts := append(chromedp.DefaultExecAllocatorOptions[:],
-		chromedp.Flag("incognito", true),
+	// Create a new Chrome instance, in incognito mode unless a profile is given
+	allocOpts := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.Flag("incognito", opts.UserDataDir == ""),
 		chromedp.Flag("disable-extensions", true),
-		chromedp.Flag("headless", headless),
+		chromedp.Flag("headless", opts.Headless),
 		chromedp.Flag("disable-web-security", true),
 		chromedp.Flag("disable-background-networking", false),
 		chromedp.Flag("disable-default-apps", true),
 		chromedp.Flag("no-first-run", true),
 		chromedp.Flag("no-sandbox", true),
 		chromedp.Flag("disable-gpu", true),
-		// // Set locale to German
-		chromedp.Env("LANGUAGE=de"),
-		chromedp.Flag("lang", "de-DE"),
-		chromedp.Flag("accept-language", "de-DE"),
+		// Set the portal language
+		chromedp.Env("LANGUAGE="+strings.SplitN(language, "-", 2)[0]),
+		chromedp.Flag("lang", language),
+		chromedp.Flag("accept-language", language),
 		// Increase timeouts for slow connections
 		chromedp.Flag("browser-test-mode", true),
 		chromedp.Flag("disable-background-timer-throttling", true),
 		chromedp.Flag("disable-backgrounding-occluded-windows", true),
 		chromedp.Flag("disable-renderer-backgrounding", true),
 	)
+	// Start the binary doctor reports; if none is found, chromedp fails with its own error
+	if path, err := chromeExecPath(opts.ChromePath); err == nil {
+		allocOpts = append(allocOpts, chromedp.ExecPath(path))
+	} else if opts.ChromePath != "" {
+		allocOpts = append(allocOpts, chromedp.ExecPath(opts.ChromePath))
+	}
+	if opts.UserDataDir != "" {
+		allocOpts = append(allocOpts, chromedp.UserDataDir(opts.UserDataDir))
+	}
 
-	allocCtx, cancel := chromedp.NewExecAllocator(context.Background(), opts...)
-	defer cancel()
+	allocCtx, cancelAlloc := chromedp.NewExecAllocator(context.Background(), allocOpts...)
 
 	// Create a new browser with longer timeout
-	ctx, cancel := chromedp.NewContext(
-		allocCtx,
-		chromedp.WithLogf(func(format string, args ...interface{}) {
-			log.Debug(fmt.Sprintf(format, args...), "source", "chromedp")
-		}),
-	)
-	defer cancel()
+	ctx, cancelBrowser := chromedp.NewContext(allocCtx, logf)
+	return trackNetwork(ctx), func() {
+		cancelBrowser()
+		cancelAlloc()
+	}
+}
 
-	// Set a timeout for the entire operation
-	ctx, cancel = context.WithTimeout(ctx, time.Duration(timeoutMinutes)*time.Minute)
-	defer cancel()
+// chromeLocations are the binaries looked for if no --chrome-path is given, by
+// operating system. They mirror the search of chromedp v0.13.0, which isn't exported.
+var chromeLocations = map[string][]string{
+	"darwin": {
+		"/Applications/Chromium.app/Contents/MacOS/Chromium",
+		"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+	},
+	"windows": {
+		"chrome",
+		"chrome.exe",
+		`C:\Program Files (x86)\Google\Chrome\Application\chrome.exe`,
+		`C:\Program Files\Google\Chrome\Application\chrome.exe`,
+		filepath.Join(os.Getenv("USERPROFILE"), `AppData\Local\Google\Chrome\Application\chrome.exe`),
+		filepath.Join(os.Getenv("USERPROFILE"), `AppData\Local\Chromium\Application\chrome.exe`),
+	},
+	"unix": {
+		"headless_shell",
+		"headless-shell",
+		"chromium",
+		"chromium-browser",
+		"google-chrome",
+		"google-chrome-stable",
+		"google-chrome-beta",
+		"google-chrome-unstable",
+		"/usr/bin/google-chrome",
+		"/usr/local/bin/chrome",
+		"/snap/bin/chromium",
+		"chrome",
+	},
+}
 
-	// Step 1: Navigate to the login page
-	log.Info("Navigating to login page")
-	if err := chromedp.Run(ctx, chromedp.Navigate(siteURL)); err != nil {
-		return fmt.Errorf("failed to navigate to login page: %v", err)
+// chromeExecPath resolves the Chrome binary to start: the given one, or else the
+// first of chromeLocations found. Both newBrowser and doctor use it, so that
+// doctor checks the binary download starts.
+func chromeExecPath(chromePath string) (string, error) {
+	if chromePath != "" {
+		return exec.LookPath(chromePath)
 	}
-
-	// Step 2: Input username with more resilient waiting
-	if err := waitForElement(ctx, "#login-form_username", 30*time.Second); err != nil {
-		return fmt.Errorf("failed to find username field: %v", err)
+	locations, ok := chromeLocations[runtime.GOOS]
+	if !ok {
+		locations = chromeLocations["unix"]
 	}
-
-	log.Info("Entering username")
-	if err := chromedp.Run(ctx,
-		chromedp.Sleep(1*time.Second),
-		chromedp.Evaluate(`
-			(function() {
-				const usernameField = document.querySelector("#login-form_username");
-				if (usernameField && usernameField.shadowRoot) {
-					const input = usernameField.shadowRoot.querySelector("#input");
-					if (input) {
-						input.focus();
-						input.value = "`+username+`";
-						input.dispatchEvent(new Event('input', { bubbles: true }));
-						input.dispatchEvent(new Event('change', { bubbles: true }));
-						return true;
-					}
-				}
-				return false;
-			})()
-		`, nil),
-		chromedp.Sleep(1*time.Second),
-		chromedp.Click(`#verifUseridBtn`, chromedp.ByQuery),
-	); err != nil {
-		return fmt.Errorf("failed to input username: %v", err)
+	for _, location := range locations {
+		if path, err := exec.LookPath(location); err == nil {
+			return path, nil
+		}
 	}
+	return "", fmt.Errorf("none of %s found, set --chrome-path", strings.Join(locations, ", "))
+}
 
-	// Step 3: Input password with more resilient waiting
-	if err := waitForElement(ctx, "#login-form_password", 30*time.Second); err != nil {
-		return fmt.Errorf("failed to find password field: %v", err)
-	}
+// portalStep is one step of the way from the login page to the document list
+type portalStep struct {
+	// Name describes the step in doctor's report
+	Name string
+	Run  func(ctx context.Context) error
+}
 
-	log.Info("Entering password")
-	if err := chromedp.Run(ctx,
-		chromedp.Sleep(1*time.Second),
-		chromedp.Evaluate(`
-			(function() {
-				const passwordField = document.querySelector("#login-form_password");
-				if (passwordField && passwordField.shadowRoot) {
-					const input = passwordField.shadowRoot.querySelector("#input");
-					if (input) {
-						input.focus();
-						input.value = "`+password+`";
-						input.dispatchEvent(new Event('input', { bubbles: true }));
-						input.dispatchEvent(new Event('change', { bubbles: true }));
-						return true;
-					}
-				}
-				return false;
-			})()
-		`, nil),
-		chromedp.Sleep(1*time.Second),
-		chromedp.Click(`#signBtn`, chromedp.ByQuery),
-	); err != nil {
-		return fmt.Errorf("failed to input password: %v", err)
-	}
+// portalSession collects what the login steps found out about the portal
+type portalSession struct {
+	// Locale is the language the portal is displayed in
+	Locale Locale
+	// AllDocuments is the pattern that matched the button leading to the document list
+	AllDocuments string
+	// LoggedIn is set if the browser was logged in already, skipping the credential steps
+	LoggedIn bool
+}
 
-	log.Info("Logged in successfully")
+// loginSteps returns the steps from opening the login page to showing the document list
+func loginSteps(siteURL, username, password string, portalLocales []Locale, selectors SelectorProfile, session *portalSession) []portalStep {
+	var usernameSelector, usernameSubmit, passwordSelector, passwordSubmit string
+	return []portalStep{
+		{"Open login page", func(ctx context.Context) error {
+			// Step 1: Navigate to the login page
+			log.Info("Navigating to login page")
+			if err := chromedp.Run(ctx, chromedp.Navigate(siteURL)); err != nil {
+				return fmt.Errorf("failed to navigate to login page: %v", err)
+			}
+			return nil
+		}},
+		{"Find username field", func(ctx context.Context) (err error) {
+			// Step 2: Input username with more resilient waiting
+			if usernameSelector, err = waitForLoginOrDashboard(ctx, portalLocales, selectors, 30*time.Second); err != nil {
+				return fmt.Errorf("failed to find username field: %v", err)
+			}
+			if usernameSelector == "" {
+				log.Info("Already logged in")
+				session.LoggedIn = true
+				return nil
+			}
+			if usernameSubmit, err = waitForAnyElement(ctx, selectors.UsernameSubmit, 10*time.Second); err != nil {
+				return fmt.Errorf("failed to find username button: %v", err)
+			}
+			return nil
+		}},
+		{"Enter username", func(ctx context.Context) error {
+			if session.LoggedIn {
+				return nil
+			}
+			log.Info("Entering username")
+			if err := chromedp.Run(ctx,
+				fillField(usernameSelector, username),
+				chromedp.Click(usernameSubmit, chromedp.ByQuery),
+			); err != nil {
+				return fmt.Errorf("failed to input username: %v", err)
+			}
+			return nil
+		}},
+		{"Find password field", func(ctx context.Context) (err error) {
+			if session.LoggedIn {
+				return nil
+			}
+			// Step 3: Input password with more resilient waiting
+			if passwordSelector, err = waitForAnyElement(ctx, selectors.Password, 30*time.Second); err != nil {
+				return fmt.Errorf("failed to find password field: %v", err)
+			}
+			if passwordSubmit, err = waitForAnyElement(ctx, selectors.PasswordSubmit, 10*time.Second); err != nil {
+				return fmt.Errorf("failed to find sign in button: %v", err)
+			}
+			return nil
+		}},
+		{"Enter password", func(ctx context.Context) error {
+			if session.LoggedIn {
+				return nil
+			}
+			log.Info("Entering password")
+			if err := chromedp.Run(ctx,
+				fillField(passwordSelector, password),
+				chromedp.Click(passwordSubmit, chromedp.ByQuery),
+			); err != nil {
+				return fmt.Errorf("failed to input password: %v", err)
+			}
+			return nil
+		}},
+		{"Wait for dashboard", func(ctx context.Context) (err error) {
+			// Step 4: Navigate to All Documents page
+			log.Info("Waiting for dashboard to load")
+			if session.Locale, session.AllDocuments, err = waitForDashboard(ctx, portalLocales, selectors, 30*time.Second); err != nil {
+				return fmt.Errorf("failed to find 'Alle Dokumente' button: %v", err)
+			}
+			log.Info("Logged in successfully")
+			log.Debug("Detected portal language", "locale", session.Locale.Name)
+			return nil
+		}},
+		{"Open document list", func(ctx context.Context) error {
+			log.Info("Navigating to All Documents page")
+			// Let the dashboard finish loading its tiles
+			if err := runUntilNetworkIdle(ctx, 30*time.Second); err != nil {
+				return fmt.Errorf("failed to wait for dashboard to load: %v", err)
+			}
+			allDocuments, err := regexp.Compile(session.AllDocuments)
+			if err != nil {
+				return err
+			}
+			if err := runUntilNetworkIdle(ctx, 30*time.Second, clickByText(allDocuments)); err != nil {
+				return fmt.Errorf("failed to find and click 'Alle Dokumente' button: %v", err)
+			}
+			return nil
+		}},
+	}
+}
 
-	// Step 4: Navigate to All Documents page
-	log.Info("Waiting for dashboard to load")
+func downloadPDFs(siteURL, username, password, downloadPath string, browser browserOptions, timeoutMinutes int, index *Index, portalLocales []Locale, selectors SelectorProfile, debugDir string) (err error) {
+	browserCtx, cancel := newBrowser(browser, portalLocales)
+	defer cancel()
 
-	if err := waitForText(ctx, "Alle Dokumente \\(\\d+\\)", 30*time.Second); err != nil {
-		return fmt.Errorf("failed to find 'Alle Dokumente' button: %v", err)
+	// Record the browser's activity and write it out if anything fails
+	if recorder := newDebugRecorder(debugDir, username, password); recorder != nil {
+		recorder.listen(browserCtx)
+		defer func() {
+			if err != nil {
+				recorder.capture(browserCtx, err)
+			}
+		}()
 	}
 
-	log.Info("Navigating to All Documents page")
-	if err := chromedp.Run(ctx,
-		// TODO: more resilient
-		// chromedp.WaitVisible("#ePayslipTile\\:ePayTileForm\\:j_idt568", chromedp.ByQuery),
-		chromedp.Sleep(3*time.Second),
-		// Find and click the "Alle Dokumente" button using JavaScript
-		chromedp.Evaluate(`
-			(function() {
-				// Find all buttons, links, or elements with role="button"
-				const elements = document.querySelectorAll('button, a, [role="button"]');
-				// Find the first one containing "Alle Dokumente"
-				for (const el of elements) {
-					if (el.textContent.includes("Alle Dokumente")) {
-						el.click();
-						return true;
-					}
-				}
-				return false;
-			})()
-		`, nil),
-		// Wait a bit for navigation to complete
-		chromedp.Sleep(2*time.Second),
-	); err != nil {
-		return fmt.Errorf("failed to find and click 'Alle Dokumente' button: %v", err)
+	// Set a timeout for the entire operation
+	ctx, cancel := context.WithTimeout(browserCtx, time.Duration(timeoutMinutes)*time.Minute)
+	defer cancel()
+
+	// Log in and open the document list
+	var session portalSession
+	for _, step := range loginSteps(siteURL, username, password, portalLocales, selectors, &session) {
+		if err := step.Run(ctx); err != nil {
+			return err
+		}
 	}
+	locale := session.Locale
 
 	// Step 5: Get cookies after navigating to the documents page
 	log.Info("Getting cookies for document access")
@@ -372,7 +596,7 @@ func downloadPDFs(siteURL, username, password, downloadPath string, headless boo
 	log.Info("Cookie setup complete", "cookie_count", len(cookies))
 
 	// Find all PDF links
-	pdfLinks, err := findPDFLinks(ctx)
+	pdfLinks, err := findPDFLinks(ctx, locale, selectors)
 	if err != nil {
 		return fmt.Errorf("failed to find PDF links: %v", err)
 	}
@@ -392,15 +616,27 @@ func downloadPDFs(siteURL, username, password, downloadPath string, headless boo
 		filename := fmt.Sprintf("adp_%d.pdf", i+1)
 
 		// Download the PDF
-		if err := downloadFile(client, link, filepath.Join(downloadPath, filename)); err != nil {
+		path := filepath.Join(downloadPath, filename)
+		if err := downloadFile(client, link, path); err != nil {
 			return fmt.Errorf("failed to download %s: %v", link, err)
 		}
+
+		// Record the download in the index
+		if index != nil {
+			hash, err := fileSHA256(path)
+			if err == nil {
+				err = index.RecordDownload(hash, link, path)
+			}
+			if err != nil {
+				log.Warn("Failed to index download", "path", path, "error", err)
+			}
+		}
 	}
 
 	return nil
 }
 
-func findPDFLinks(ctx context.Context) ([]string, error) {
+func findPDFLinks(ctx context.Context, locale Locale, selectors SelectorProfile) ([]string, error) {
 	var pdfLinks []string
 	var hasMorePages = true
 	var currentPage = 1
@@ -409,13 +645,14 @@ func findPDFLinks(ctx context.Context) ([]string, error) {
 	for hasMorePages {
 		log.Info("Processing document page", "page", currentPage)
 
+		// Wait for the document list to appear
+		if _, err := waitForAnyElement(ctx, selectors.DocumentTable, 60*time.Second); err != nil {
+			return nil, fmt.Errorf("failed to find document list: %v", err)
+		}
+
 		// Get the HTML content of the current page
 		var html string
-		if err := chromedp.Run(ctx,
-			// Wait for the document list to appear
-			chromedp.WaitVisible("#epaysliplist\\:ePayListForm\\:ePayslipDocs > div.ui-datatable-tablewrapper > table", chromedp.ByQuery),
-			chromedp.OuterHTML("html", &html),
-		); err != nil {
+		if err := chromedp.Run(ctx, chromedp.OuterHTML("html", &html)); err != nil {
 			return nil, fmt.Errorf("failed to get document page content: %v", err)
 		}
 
@@ -428,8 +665,10 @@ func findPDFLinks(ctx context.Context) ([]string, error) {
 
 		// Find PDF links on current page
 		var pageLinks []string
-		doc.Find("a").Each(func(i int, s *goquery.Selection) {
-			if href, exists := s.Attr("href"); exists && strings.Contains(href, "/AdpwAdpaWeb/DocDownload") {
+		seen := make(map[string]bool)
+		doc.Find(strings.Join(selectors.DocumentLink, ", ")).Each(func(i int, s *goquery.Selection) {
+			if href, exists := s.Attr("href"); exists && !seen[href] {
+				seen[href] = true
 				pageLinks = append(pageLinks, href)
 			}
 		})
@@ -438,32 +677,38 @@ func findPDFLinks(ctx context.Context) ([]string, error) {
 		pdfLinks = append(pdfLinks, pageLinks...)
 
 		// Check if there's a next page button that's not disabled
-		var nextPageDisabled bool
-		nextPageSelector := `a[aria-label="Nächste Seite"]`
+		candidates := append(append([]string{}, selectors.NextPage...), fmt.Sprintf(`a[aria-label=%q]`, locale.NextPage))
+		candidatesJSON, err := json.Marshal(candidates)
+		if err != nil {
+			return nil, err
+		}
 
-		// First check if the next page button exists and is not disabled
+		// Find the first next page button that exists, empty if it is missing or disabled
+		var nextPageSelector string
 		if err := chromedp.Run(ctx, chromedp.Evaluate(`
-			(function() {
-				const nextBtn = document.querySelector('a[aria-label="Nächste Seite"]');
-				return !nextBtn || nextBtn.classList.contains('ui-state-disabled');
-			})()
-		`, &nextPageDisabled)); err != nil {
+			(function(selectors) {
+				for (const selector of selectors) {
+					const nextBtn = document.querySelector(selector);
+					if (nextBtn) {
+						return nextBtn.classList.contains('ui-state-disabled') ? "" : selector;
+					}
+				}
+				return "";
+			})(`+string(candidatesJSON)+`)
+		`, &nextPageSelector)); err != nil {
 			return nil, fmt.Errorf("failed to check next page button: %v", err)
 		}
 
-		if nextPageDisabled {
+		if nextPageSelector == "" {
 			// No more pages
 			hasMorePages = false
 			log.Info("Reached last page", "total_pages", currentPage)
 		} else {
 			// Click next page button
 			log.Info("Navigating to next page")
-			if err := chromedp.Run(ctx,
+			// The page is replaced by a request; wait for it to load
+			if err := runUntilNetworkIdle(ctx, 60*time.Second,
 				chromedp.Click(nextPageSelector, chromedp.ByQuery),
-				// Wait for page to load
-				chromedp.Sleep(2*time.Second),
-				// Wait for the table to be visible again
-				chromedp.WaitVisible("#epaysliplist\\:ePayListForm\\:ePayslipDocs > div.ui-datatable-tablewrapper > table", chromedp.ByQuery),
 			); err != nil {
 				return nil, fmt.Errorf("failed to navigate to next page: %v", err)
 			}
@@ -476,11 +721,13 @@ func findPDFLinks(ctx context.Context) ([]string, error) {
 }
 
 func downloadFile(client *http.Client, urlStr, filepath string) error {
-	// Create the file
-	out, err := os.Create(filepath)
+	// Write to a temporary name so that a partial download is never taken for a PDF
+	partPath := filepath + ".part"
+	out, err := os.Create(partPath)
 	if err != nil {
 		return err
 	}
+	defer os.Remove(partPath)
 	defer out.Close()
 
 	// Get the data
@@ -501,6 +748,12 @@ func downloadFile(client *http.Client, urlStr, filepath string) error {
 	if err != nil {
 		return err
 	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(partPath, filepath); err != nil {
+		return err
+	}
 
 	log.Info("Successfully downloaded file", "path", filepath, "size_bytes", resp.ContentLength)
 	return nil
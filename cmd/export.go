@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// ledgerFormats lists the supported plain-text accounting formats
+var ledgerFormats = []string{"beancount", "hledger", "ledger"}
+
+// ledgerAccounts holds the account names used for exported transactions
+type ledgerAccounts struct {
+	Bank            string
+	Income          string
+	Taxes           string
+	SocialInsurance string
+	Other           string
+	Currency        string
+}
+
+// ledgerPosting is a single leg of a transaction
+type ledgerPosting struct {
+	Account string
+	Amount  Money
+}
+
+// ledgerTransaction is a balanced transaction derived from a payslip
+type ledgerTransaction struct {
+	Date      time.Time
+	Narration string
+	// Period is the Abrechnungsmonat of the payslip
+	Period Period
+	// Correction is the month corrected by a Rückrechnung
+	Correction *Period
+	Source     string
+	Postings   []ledgerPosting
+}
+
+// NewExportCmd creates and configures the export command
+func NewExportCmd(config Config) *cobra.Command {
+	var (
+		pdfPaths   []string
+		walk       walkOptions
+		format     string
+		outputPath string
+		accounts   ledgerAccounts
+		read       readFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export",
+		Short: "Export payslips to plain-text accounting",
+		Long: `Export all payslips as balanced transactions for beancount, hledger or ledger.
+Each Verdienstabrechnung splits the gross income into taxes, social insurance
+contributions and the net pay transferred to the bank account. Rückrechnung
+payslips are exported as correcting transactions holding the difference to the
+previous version of the corrected month.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !isLedgerFormat(format) {
+				log.Error("Unsupported export format", "format", format, "supported", ledgerFormats)
+				os.Exit(1)
+			}
+
+			if err := checkWalkFlags(pdfPaths, walk); err != nil {
+				log.Error("Invalid PDF selection", "error", err)
+				os.Exit(1)
+			}
+
+			opts, err := read.readOptions(cmd, false)
+			if err != nil {
+				log.Error("Error setting up text extraction", "error", err)
+				os.Exit(1)
+			}
+
+			var out io.Writer = os.Stdout
+			if outputPath != "" {
+				f, err := os.Create(outputPath)
+				if err != nil {
+					log.Error("Failed to create output file", "path", outputPath, "error", err)
+					os.Exit(1)
+				}
+				defer f.Close()
+				out = f
+			}
+
+			if err := exportLedger(pdfPaths, walk, format, accounts, opts, out); err != nil {
+				log.Error("Error exporting payslips", "error", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addWalkFlags(cmd, config, &pdfPaths, &walk)
+	cmd.Flags().StringVar(&format, "format", "beancount", "Output format (beancount, hledger, ledger)")
+	cmd.Flags().StringVar(&outputPath, "out-file", "", "Write to file instead of stdout")
+	cmd.Flags().StringVar(&accounts.Bank, "bank-account", "Assets:Bank:Checking", "Account receiving the net pay")
+	cmd.Flags().StringVar(&accounts.Income, "income-account", "Income:Salary", "Account for the gross income")
+	cmd.Flags().StringVar(&accounts.Taxes, "tax-account", "Expenses:Taxes", "Parent account for withheld taxes")
+	cmd.Flags().StringVar(&accounts.SocialInsurance, "social-insurance-account", "Expenses:SocialInsurance", "Parent account for social insurance contributions")
+	cmd.Flags().StringVar(&accounts.Other, "other-account", "Expenses:Payroll:Other", "Account for remaining deductions and allowances")
+	cmd.Flags().StringVar(&accounts.Currency, "currency", "EUR", "Currency of all amounts")
+	addReadFlags(cmd, config, &read)
+
+	return cmd
+}
+
+func isLedgerFormat(format string) bool {
+	for _, f := range ledgerFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+func exportLedger(pdfPaths []string, walk walkOptions, format string, accounts ledgerAccounts, opts readOptions, out io.Writer) error {
+	docs, err := loadDocuments(pdfPaths, walk, opts)
+	if err != nil {
+		return err
+	}
+
+	payslips := parsePayslips(docs)
+
+	log.Info("Exporting payslips", "count", len(payslips), "format", format)
+
+	transactions := buildLedgerTransactions(payslips, accounts)
+	return writeLedger(out, format, accounts.Currency, transactions)
+}
+
+// buildLedgerTransactions turns payslips ordered by Abrechnungsmonat into transactions.
+// A Rückrechnung is booked as the difference to the previous version of the corrected month.
+func buildLedgerTransactions(payslips []Payslip, accounts ledgerAccounts) []ledgerTransaction {
+	latest := make(map[Period]Payslip)
+	var transactions []ledgerTransaction
+
+	for _, payslip := range payslips {
+		effective := payslip.EffectivePeriod()
+		txn := ledgerTransaction{
+			Date:       payslip.Period.LastDay(),
+			Narration:  fmt.Sprintf("Verdienstabrechnung %s", payslip.Period),
+			Period:     payslip.Period,
+			Correction: payslip.Correction,
+			Source:     filepath.Base(payslip.Path),
+		}
+
+		amounts := payslip
+		if payslip.IsCorrection() {
+			txn.Narration = fmt.Sprintf("Rückrechnung %d %s", payslip.CorrectionNumber, effective)
+			if previous, ok := latest[effective]; ok {
+				amounts = payslip.minus(previous)
+			} else {
+				log.Warn("No previous payslip for corrected month, exporting full amounts",
+					"filename", txn.Source, "corrected", effective)
+			}
+		}
+		latest[effective] = payslip
+
+		txn.Postings = ledgerPostings(amounts, accounts)
+		transactions = append(transactions, txn)
+	}
+
+	return transactions
+}
+
+// ledgerPostings splits a payslip into balanced postings
+func ledgerPostings(p Payslip, accounts ledgerAccounts) []ledgerPosting {
+	postings := []ledgerPosting{{Account: accounts.Income, Amount: -p.Gross}}
+
+	deductions := []ledgerPosting{
+		{Account: accounts.Taxes + ":Lohnsteuer", Amount: p.IncomeTax},
+		{Account: accounts.Taxes + ":Solidaritaetszuschlag", Amount: p.SolidaritySurcharge},
+		{Account: accounts.Taxes + ":Kirchensteuer", Amount: p.ChurchTax},
+		{Account: accounts.SocialInsurance + ":Krankenversicherung", Amount: p.HealthInsurance},
+		{Account: accounts.SocialInsurance + ":Rentenversicherung", Amount: p.PensionInsurance},
+		{Account: accounts.SocialInsurance + ":Arbeitslosenversicherung", Amount: p.UnemploymentInsurance},
+		{Account: accounts.SocialInsurance + ":Pflegeversicherung", Amount: p.CareInsurance},
+	}
+	for _, posting := range deductions {
+		if posting.Amount != 0 {
+			postings = append(postings, posting)
+		}
+	}
+
+	if p.Payout != 0 {
+		postings = append(postings, ledgerPosting{Account: accounts.Bank, Amount: p.Payout})
+	}
+
+	// Anything not covered above (e.g. VWL, Sachbezüge, Zuschüsse) balances the transaction
+	if rest := p.Gross - p.Taxes() - p.SocialInsurance() - p.Payout; rest != 0 {
+		postings = append(postings, ledgerPosting{Account: accounts.Other, Amount: rest})
+	}
+
+	return postings
+}
+
+// writeLedger renders transactions in the given plain-text accounting format
+func writeLedger(w io.Writer, format, currency string, transactions []ledgerTransaction) error {
+	// beancount rejects postings to accounts that were never opened
+	if format == "beancount" && len(transactions) > 0 {
+		if err := writeBeancountOpen(w, currency, transactions); err != nil {
+			return err
+		}
+	}
+
+	for i, txn := range transactions {
+		if i > 0 {
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		switch format {
+		case "beancount":
+			err = writeBeancountTransaction(w, currency, txn)
+		case "hledger":
+			err = writeHledgerTransaction(w, currency, txn)
+		case "ledger":
+			err = writeLedgerTransaction(w, currency, txn)
+		default:
+			err = fmt.Errorf("unsupported format: %s", format)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBeancountOpen opens every account used by the transactions on the date
+// of the earliest one
+func writeBeancountOpen(w io.Writer, currency string, transactions []ledgerTransaction) error {
+	opened := transactions[0].Date
+	var accounts []string
+	for _, txn := range transactions {
+		if txn.Date.Before(opened) {
+			opened = txn.Date
+		}
+		for _, posting := range txn.Postings {
+			accounts = appendUnique(accounts, posting.Account)
+		}
+	}
+	sort.Strings(accounts)
+
+	for _, account := range accounts {
+		if _, err := fmt.Fprintf(w, "%s open %s %s\n", opened.Format("2006-01-02"), account, currency); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w)
+	return err
+}
+
+func writeBeancountTransaction(w io.Writer, currency string, txn ledgerTransaction) error {
+	fmt.Fprintf(w, "%s * \"ADP\" %q\n", txn.Date.Format("2006-01-02"), txn.Narration)
+	fmt.Fprintf(w, "  abrechnungsmonat: %q\n", txn.Period.Key())
+	if txn.Correction != nil {
+		fmt.Fprintf(w, "  korrekturmonat: %q\n", txn.Correction.Key())
+	}
+	fmt.Fprintf(w, "  source: %q\n", txn.Source)
+	return writePostings(w, "  ", currency, txn.Postings)
+}
+
+func writeHledgerTransaction(w io.Writer, currency string, txn ledgerTransaction) error {
+	fmt.Fprintf(w, "%s %s  ; abrechnungsmonat:%s", txn.Date.Format("2006-01-02"), txn.Narration, txn.Period.Key())
+	if txn.Correction != nil {
+		fmt.Fprintf(w, ", korrekturmonat:%s", txn.Correction.Key())
+	}
+	fmt.Fprintf(w, ", source:%s\n", txn.Source)
+	return writePostings(w, "    ", currency, txn.Postings)
+}
+
+func writeLedgerTransaction(w io.Writer, currency string, txn ledgerTransaction) error {
+	fmt.Fprintf(w, "%s %s\n", txn.Date.Format("2006/01/02"), txn.Narration)
+	fmt.Fprintf(w, "    ; abrechnungsmonat: %s\n", txn.Period.Key())
+	if txn.Correction != nil {
+		fmt.Fprintf(w, "    ; korrekturmonat: %s\n", txn.Correction.Key())
+	}
+	fmt.Fprintf(w, "    ; source: %s\n", txn.Source)
+	return writePostings(w, "    ", currency, txn.Postings)
+}
+
+func writePostings(w io.Writer, indent, currency string, postings []ledgerPosting) error {
+	for _, posting := range postings {
+		if _, err := fmt.Fprintf(w, "%s%-50s  %12s %s\n", indent, posting.Account, posting.Amount, currency); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testLedgerAccounts = ledgerAccounts{
+	Bank:            "Assets:Bank:Checking",
+	Income:          "Income:Salary",
+	Taxes:           "Expenses:Taxes",
+	SocialInsurance: "Expenses:SocialInsurance",
+	Other:           "Expenses:Payroll:Other",
+	Currency:        "EUR",
+}
+
+func testPayslip(period Period, gross, incomeTax, health, payout Money) Payslip {
+	return Payslip{
+		Document:        Document{Path: "/tmp/" + period.Key() + ".pdf", Type: DocumentTypePayslip, Period: period},
+		Gross:           gross,
+		IncomeTax:       incomeTax,
+		HealthInsurance: health,
+		Payout:          payout,
+	}
+}
+
+func postingsSum(postings []ledgerPosting) Money {
+	var sum Money
+	for _, posting := range postings {
+		sum += posting.Amount
+	}
+	return sum
+}
+
+func TestLedgerPostingsBalance(t *testing.T) {
+	tests := []struct {
+		name    string
+		payslip Payslip
+		// other is the expected amount on the other account, zero if not posted
+		other Money
+	}{
+		{"fully covered", testPayslip(Period{2023, time.January}, 500000, 100000, 40000, 360000), 0},
+		{"remaining deduction", testPayslip(Period{2023, time.February}, 500000, 100000, 40000, 355000), 5000},
+		{"allowance", testPayslip(Period{2023, time.March}, 500000, 100000, 40000, 365000), -5000},
+		{"negative correction", testPayslip(Period{2023, time.April}, -10000, -2000, -800, -7200), 0},
+		{"odd cents", testPayslip(Period{2023, time.May}, 333333, 111111, 1, 222220), 1},
+	}
+	for _, tt := range tests {
+		postings := ledgerPostings(tt.payslip, testLedgerAccounts)
+		if sum := postingsSum(postings); sum != 0 {
+			t.Errorf("%s: postings sum to %s, want 0", tt.name, sum)
+		}
+		var other Money
+		for _, posting := range postings {
+			if posting.Account == testLedgerAccounts.Other {
+				other = posting.Amount
+			}
+		}
+		if other != tt.other {
+			t.Errorf("%s: other account = %s, want %s", tt.name, other, tt.other)
+		}
+	}
+}
+
+func TestBuildLedgerTransactionsCorrection(t *testing.T) {
+	january := Period{2023, time.January}
+	original := testPayslip(january, 500000, 100000, 40000, 360000)
+	correction := testPayslip(Period{2023, time.March}, 520000, 105000, 41000, 374000)
+	correction.Correction = &january
+	correction.CorrectionNumber = 1
+
+	transactions := buildLedgerTransactions([]Payslip{original, correction}, testLedgerAccounts)
+	if len(transactions) != 2 {
+		t.Fatalf("got %d transactions, want 2", len(transactions))
+	}
+
+	// The Rückrechnung books the difference to the original
+	want := map[string]Money{
+		testLedgerAccounts.Income:                                   -20000,
+		testLedgerAccounts.Taxes + ":Lohnsteuer":                    5000,
+		testLedgerAccounts.SocialInsurance + ":Krankenversicherung": 1000,
+		testLedgerAccounts.Bank:                                     14000,
+	}
+	got := make(map[string]Money)
+	for _, posting := range transactions[1].Postings {
+		got[posting.Account] = posting.Amount
+	}
+	for account, amount := range want {
+		if got[account] != amount {
+			t.Errorf("%s = %s, want %s", account, got[account], amount)
+		}
+	}
+	if sum := postingsSum(transactions[1].Postings); sum != 0 {
+		t.Errorf("correction postings sum to %s, want 0", sum)
+	}
+}
+
+func TestWriteLedgerBeancountOpensAccounts(t *testing.T) {
+	payslips := []Payslip{
+		testPayslip(Period{2023, time.February}, 500000, 100000, 40000, 355000),
+		testPayslip(Period{2023, time.January}, 500000, 100000, 40000, 360000),
+	}
+	transactions := buildLedgerTransactions(payslips, testLedgerAccounts)
+
+	var buf bytes.Buffer
+	if err := writeLedger(&buf, "beancount", "EUR", transactions); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+
+	for _, account := range []string{
+		testLedgerAccounts.Income,
+		testLedgerAccounts.Bank,
+		testLedgerAccounts.Other,
+		testLedgerAccounts.Taxes + ":Lohnsteuer",
+		testLedgerAccounts.SocialInsurance + ":Krankenversicherung",
+	} {
+		// Accounts are opened on the earliest transaction's date, before any posting
+		open := "2023-01-31 open " + account + " EUR\n"
+		i := strings.Index(out, open)
+		if i < 0 {
+			t.Errorf("missing %q", open)
+			continue
+		}
+		if j := strings.Index(out, " * "); j >= 0 && j < i {
+			t.Errorf("%s opened after the first transaction", account)
+		}
+	}
+	if n := strings.Count(out, " open "); n != 5 {
+		t.Errorf("got %d open directives, want 5", n)
+	}
+
+	buf.Reset()
+	if err := writeLedger(&buf, "hledger", "EUR", transactions); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), " open ") {
+		t.Error("hledger output contains open directives")
+	}
+}
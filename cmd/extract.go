@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// readOptions controls how the text of PDFs is extracted
+type readOptions struct {
+	// OCR recognizes the text of PDFs with less than OCRMinText characters of
+	// embedded text, if set
+	OCR        OCREngine
+	OCRMinText int
+	// Passwords are tried on password-protected PDFs
+	Passwords []string
+	// Cache stores extracted text and classifications across runs, if set
+	Cache *Cache
+	// CacheDecrypted also caches the text of password-protected PDFs, which is
+	// otherwise never stored in plaintext
+	CacheDecrypted bool
+	// Locales are tried when classifying documents, all of them if empty
+	Locales []Locale
+}
+
+// readFlags holds the command line flags every command extracting text shares
+type readFlags struct {
+	cacheDir      string
+	ocrEngine     string
+	ocrLanguage   string
+	ocrMinText    int
+	passwords     []string
+	passwordsFile string
+	localeName    string
+}
+
+// addReadFlags adds the flags controlling text extraction to a command
+func addReadFlags(cmd *cobra.Command, config Config, flags *readFlags) {
+	cmd.Flags().StringVar(&flags.cacheDir, "cache", filepath.Join(config.DataDir, "cache"), "Directory caching extracted text and classifications (empty to disable)")
+	cmd.Flags().StringVar(&flags.ocrEngine, "ocr", "auto", "OCR engine for scanned PDFs (auto, off, tesseract)")
+	cmd.Flags().StringVar(&flags.ocrLanguage, "ocr-lang", "deu", "Tesseract language(s) of scanned PDFs, e.g. deu+eng")
+	cmd.Flags().IntVar(&flags.ocrMinText, "ocr-min-text", 50, "Use OCR if a PDF has fewer characters of embedded text")
+	cmd.Flags().StringArrayVar(&flags.passwords, "pdf-password", nil, "Password of password-protected PDFs, e.g. a birth date (repeatable, defaults to "+pdfPasswordsEnv+")")
+	cmd.Flags().StringVar(&flags.localeName, "locale", "auto", "Language of the documents (auto, de, en)")
+	cmd.Flags().StringVar(&flags.passwordsFile, "pdf-passwords-file", filepath.Join(config.DataDir, pdfPasswordsFile), "File with one password of password-protected PDFs per line, tried after the others")
+}
+
+// readOptions sets up OCR, passwords and the cache from the flags. Passwords
+// are taken from --pdf-password, or else the environment, followed by the
+// passwords file. A read-only cache is only used if it exists and never written to.
+func (f readFlags) readOptions(cmd *cobra.Command, readOnlyCache bool) (readOptions, error) {
+	opts := readOptions{OCRMinText: f.ocrMinText, Passwords: f.passwords}
+	var err error
+	if opts.Locales, err = selectLocales(f.localeName); err != nil {
+		return opts, err
+	}
+	if !cmd.Flags().Changed("pdf-password") {
+		opts.Passwords = envPDFPasswords()
+	}
+	if f.passwordsFile != "" {
+		stored, err := readPasswordsFile(f.passwordsFile)
+		if err != nil {
+			return opts, fmt.Errorf("failed to read passwords: %v", err)
+		}
+		opts.Passwords = append(opts.Passwords, stored...)
+	}
+
+	if !isOCREngine(f.ocrEngine) {
+		return opts, fmt.Errorf("unsupported OCR engine %q, supported: %v", f.ocrEngine, ocrEngines)
+	}
+	if opts.OCR, err = newOCREngine(f.ocrEngine, f.ocrLanguage); err != nil {
+		return opts, fmt.Errorf("failed to set up OCR: %v", err)
+	}
+
+	switch {
+	case f.cacheDir == "":
+	case readOnlyCache:
+		if opts.Cache, err = OpenCacheReadOnly(f.cacheDir); err != nil && !os.IsNotExist(err) {
+			return opts, fmt.Errorf("failed to open cache: %v", err)
+		}
+	default:
+		if opts.Cache, err = OpenCache(f.cacheDir); err != nil {
+			return opts, fmt.Errorf("failed to open cache: %v", err)
+		}
+	}
+	return opts, nil
+}
+
+// readDocument extracts and classifies a PDF. Password-protected PDFs are read
+// from their stored decrypted copy or a decrypted temporary copy, scanned PDFs
+// are recognized with OCR, and the results are cached by content hash unless
+// hash is empty. Documents read from a temporary copy are marked as Decrypted
+// and only cached if opts.CacheDecrypted is set. Like classifyDocument, it
+// returns errPeriodNotFound for documents of a known type without a period; an
+// error for a document of unknown type means its text couldn't be read.
+func readDocument(pdfFile, hash string, opts readOptions) (Document, map[string]string, error) {
+	filename := filepath.Base(pdfFile)
+
+	// The cached text stays valid; the cached classification only for the same
+	// rules and one of the locales
+	var entry cacheEntry
+	var cached bool
+	if opts.Cache != nil && hash != "" {
+		entry, cached = opts.Cache.Lookup(hash)
+	}
+
+	// Text extracted while OCR was unavailable is recognized again
+	if cached && entry.OCR == "" && needsOCR(entry.Text, opts) {
+		cached = false
+	}
+
+	if cached && entry.RulesVersion == rulesVersion && hasLocale(opts.Locales, entry.Locale) {
+		log.Debug("Using cached classification", "filename", filename)
+		doc, err := entry.Document(pdfFile)
+		return doc, entry.Fields, err
+	}
+
+	// Password-protected PDFs are read from a decrypted copy
+	source := decryptedSource(pdfFile)
+	readPath, cleanup, err := readablePDF(source, opts.Passwords)
+	if err != nil {
+		return Document{Path: pdfFile, Hash: hash}, nil, fmt.Errorf("failed to decrypt PDF: %v", err)
+	}
+	defer cleanup()
+
+	text, ocr := entry.Text, entry.OCR
+	if !cached {
+		if text, ocr, err = extractText(readPath, opts); err != nil {
+			return Document{Path: pdfFile, Hash: hash}, nil, fmt.Errorf("failed to extract text from PDF: %v", err)
+		}
+	}
+
+	// Detect the document type and period
+	doc, err := classifyDocument(readPath, text, opts.Locales)
+	doc.Hash = hash
+	var fields map[string]string
+	if doc.Type != DocumentTypeUnknown && err == nil {
+		fields = documentFields(doc)
+	}
+	doc.Path = pdfFile
+	doc.Decrypted = readPath != source
+
+	if opts.Cache != nil && hash != "" && (!doc.Decrypted || opts.CacheDecrypted) {
+		if err := opts.Cache.Store(doc, fields, ocr); err != nil {
+			log.Warn("Failed to cache PDF", "filename", filename, "error", err)
+		}
+	}
+	return doc, fields, err
+}
@@ -0,0 +1,434 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// indexMigrations create and upgrade the tables of the document index. The number
+// of applied migrations is stored in the database's user_version pragma.
+var indexMigrations = []string{
+	// 1: documents
+	`CREATE TABLE documents (
+		hash              TEXT PRIMARY KEY,
+		link_id           TEXT NOT NULL DEFAULT '',
+		original_name     TEXT NOT NULL DEFAULT '',
+		path              TEXT NOT NULL,
+		type              TEXT NOT NULL DEFAULT '',
+		year              INTEGER NOT NULL DEFAULT 0,
+		month             INTEGER NOT NULL DEFAULT 0,
+		correction_year   INTEGER NOT NULL DEFAULT 0,
+		correction_month  INTEGER NOT NULL DEFAULT 0,
+		correction_number INTEGER NOT NULL DEFAULT 0,
+		fields            TEXT NOT NULL DEFAULT '{}',
+		downloaded_at     TEXT NOT NULL DEFAULT '',
+		updated_at        TEXT NOT NULL
+	);
+	CREATE INDEX documents_period ON documents (type, year, month);
+	CREATE INDEX documents_path ON documents (path);`,
+
+	// 2: full-text search
+	`CREATE TABLE search_documents (
+		hash             TEXT PRIMARY KEY,
+		path             TEXT NOT NULL,
+		type             TEXT NOT NULL DEFAULT '',
+		year             INTEGER NOT NULL DEFAULT 0,
+		month            INTEGER NOT NULL DEFAULT 0,
+		correction_year  INTEGER NOT NULL DEFAULT 0,
+		correction_month INTEGER NOT NULL DEFAULT 0,
+		length           INTEGER NOT NULL,
+		text             TEXT NOT NULL
+	);
+	CREATE TABLE postings (
+		term TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		tf   INTEGER NOT NULL,
+		PRIMARY KEY (term, hash)
+	);
+	CREATE INDEX postings_hash ON postings (hash);`,
+
+	// 3: full download link
+	`ALTER TABLE documents ADD COLUMN link TEXT NOT NULL DEFAULT '';`,
+}
+
+// Index is a persistent SQLite index of the document archive
+type Index struct {
+	db *sql.DB
+}
+
+// IndexEntry is a document as recorded in the index
+type IndexEntry struct {
+	Hash             string            `json:"hash"`
+	LinkID           string            `json:"link_id"`
+	Link             string            `json:"link"`
+	OriginalName     string            `json:"original_name"`
+	Path             string            `json:"path"`
+	Type             DocumentType      `json:"type"`
+	Period           string            `json:"period"`
+	CorrectedPeriod  string            `json:"corrected_period"`
+	CorrectionNumber int               `json:"correction_number"`
+	Fields           map[string]string `json:"fields"`
+	DownloadedAt     string            `json:"downloaded_at"`
+	UpdatedAt        string            `json:"updated_at"`
+}
+
+// IndexFilter restricts the entries returned by List
+type IndexFilter struct {
+	Type       DocumentType
+	Year       int
+	Correction bool
+}
+
+// OpenIndex opens or creates the index database at path. The search index holds
+// the text of payslips, so the database is only readable by the user.
+func OpenIndex(path string) (*Index, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create index directory: %v", err)
+	}
+	// Create the file before SQLite does, which would use the umask, and
+	// restrict indexes created by earlier versions
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %v", err)
+	}
+	f.Close()
+	if err := os.Chmod(path, 0600); err != nil {
+		return nil, fmt.Errorf("failed to restrict index permissions: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %v", err)
+	}
+	// SQLite allows a single writer; serialize access through one connection
+	db.SetMaxOpenConns(1)
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read index version: %v", err)
+	}
+	if version > len(indexMigrations) {
+		db.Close()
+		return nil, fmt.Errorf("index %s has version %d, newer than supported version %d", path, version, len(indexMigrations))
+	}
+	for i := version; i < len(indexMigrations); i++ {
+		if err := migrateIndex(db, i+1); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+
+	return &Index{db: db}, nil
+}
+
+// migrateIndex applies the migration to a version and records the version in one
+// transaction, so that an interrupted migration is neither half applied nor repeated
+func migrateIndex(db *sql.DB, version int) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to migrate index to version %d: %v", version, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(indexMigrations[version-1]); err != nil {
+		return fmt.Errorf("failed to migrate index to version %d: %v", version, err)
+	}
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version)); err != nil {
+		return fmt.Errorf("failed to set index version: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to migrate index to version %d: %v", version, err)
+	}
+	return nil
+}
+
+// errNoIndex is returned when opening a missing index read-only
+var errNoIndex = errors.New("no index exists yet; run download or process to create it")
+
+// errIndexOutdated is returned when opening an index of an older version read-only
+var errIndexOutdated = errors.New("index is outdated; run download or process to upgrade it")
+
+// OpenIndexReadOnly opens an existing, up-to-date index database at path
+// without creating, migrating or writing it
+func OpenIndexReadOnly(path string) (*Index, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", errNoIndex, path)
+	} else if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("sqlite", "file:"+filepath.ToSlash(path)+"?mode=ro")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index: %v", err)
+	}
+	db.SetMaxOpenConns(1)
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to read index version: %v", err)
+	}
+	if version < len(indexMigrations) {
+		db.Close()
+		return nil, fmt.Errorf("%w: %s has version %d, expected %d", errIndexOutdated, path, version, len(indexMigrations))
+	}
+	if version > len(indexMigrations) {
+		db.Close()
+		return nil, fmt.Errorf("index %s has version %d, newer than supported version %d", path, version, len(indexMigrations))
+	}
+	return &Index{db: db}, nil
+}
+
+// Close closes the index database
+func (ix *Index) Close() error {
+	return ix.db.Close()
+}
+
+// RecordDownload records a freshly downloaded file and the link it came from
+func (ix *Index) RecordDownload(hash, link, path string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err := ix.db.Exec(`
+		INSERT INTO documents (hash, link_id, link, original_name, path, downloaded_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO UPDATE SET
+			link_id = excluded.link_id,
+			link = excluded.link,
+			path = excluded.path,
+			downloaded_at = excluded.downloaded_at,
+			updated_at = excluded.updated_at`,
+		hash, documentLinkID(link), link, filepath.Base(path), path, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to record download: %v", err)
+	}
+	return nil
+}
+
+// RecordDocument records the classification of a document and its extracted fields
+func (ix *Index) RecordDocument(hash string, doc Document, fields map[string]string) error {
+	encoded, err := json.Marshal(fields)
+	if err != nil {
+		return fmt.Errorf("failed to encode fields: %v", err)
+	}
+
+	var correction Period
+	if doc.Correction != nil {
+		correction = *doc.Correction
+	}
+
+	now := time.Now().UTC().Format(time.RFC3339)
+	_, err = ix.db.Exec(`
+		INSERT INTO documents (hash, original_name, path, type, year, month,
+			correction_year, correction_month, correction_number, fields, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (hash) DO UPDATE SET
+			path = excluded.path,
+			type = excluded.type,
+			year = excluded.year,
+			month = excluded.month,
+			correction_year = excluded.correction_year,
+			correction_month = excluded.correction_month,
+			correction_number = excluded.correction_number,
+			fields = excluded.fields,
+			updated_at = excluded.updated_at`,
+		hash, filepath.Base(doc.Path), doc.Path, string(doc.Type), doc.Period.Year, int(doc.Period.Month),
+		correction.Year, int(correction.Month), doc.CorrectionNumber, string(encoded), now)
+	if err != nil {
+		return fmt.Errorf("failed to record document: %v", err)
+	}
+	return nil
+}
+
+// UpdatePath records the current location of a document
+func (ix *Index) UpdatePath(hash, path string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := ix.db.Exec(`UPDATE documents SET path = ?, updated_at = ? WHERE hash = ?`, path, now, hash); err != nil {
+		return fmt.Errorf("failed to update path: %v", err)
+	}
+	return nil
+}
+
+// UpdateHash moves the entry of a document whose content changed, e.g. after
+// metadata was embedded
+func (ix *Index) UpdateHash(oldHash, newHash string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	if _, err := ix.db.Exec(`UPDATE OR REPLACE documents SET hash = ?, updated_at = ? WHERE hash = ?`, newHash, now, oldHash); err != nil {
+		return fmt.Errorf("failed to update hash: %v", err)
+	}
+	return nil
+}
+
+// indexEntryColumns are the columns read by scanIndexEntry
+const indexEntryColumns = `hash, link_id, link, original_name, path, type, year, month,
+	correction_year, correction_month, correction_number, fields, downloaded_at, updated_at`
+
+// List returns the entries matching the filter, ordered by period
+func (ix *Index) List(filter IndexFilter) ([]IndexEntry, error) {
+	query := `SELECT ` + indexEntryColumns + ` FROM documents`
+
+	var conditions []string
+	var args []interface{}
+	if filter.Type != DocumentTypeUnknown {
+		conditions = append(conditions, "type = ?")
+		args = append(args, string(filter.Type))
+	}
+	if filter.Year != 0 {
+		conditions = append(conditions, "(year = ? OR correction_year = ?)")
+		args = append(args, filter.Year, filter.Year)
+	}
+	if filter.Correction {
+		conditions = append(conditions, "correction_year != 0")
+	}
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	query += " ORDER BY year, month, type, correction_number, path"
+
+	rows, err := ix.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query index: %v", err)
+	}
+	defer rows.Close()
+
+	var entries []IndexEntry
+	for rows.Next() {
+		entry, err := scanIndexEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// Lookup returns the entry of a document by content hash
+func (ix *Index) Lookup(hash string) (IndexEntry, bool, error) {
+	row := ix.db.QueryRow(`SELECT `+indexEntryColumns+` FROM documents WHERE hash = ?`, hash)
+	entry, err := scanIndexEntry(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return IndexEntry{}, false, nil
+	}
+	if err != nil {
+		return IndexEntry{}, false, err
+	}
+	return entry, true, nil
+}
+
+// scanIndexEntry reads an entry selected with indexEntryColumns
+func scanIndexEntry(row interface {
+	Scan(dest ...interface{}) error
+}) (IndexEntry, error) {
+	var (
+		entry               IndexEntry
+		documentType        string
+		year, month         int
+		corrYear, corrMonth int
+		fields              string
+	)
+	if err := row.Scan(&entry.Hash, &entry.LinkID, &entry.Link, &entry.OriginalName, &entry.Path, &documentType,
+		&year, &month, &corrYear, &corrMonth, &entry.CorrectionNumber, &fields,
+		&entry.DownloadedAt, &entry.UpdatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entry, err
+		}
+		return entry, fmt.Errorf("failed to read index entry: %v", err)
+	}
+
+	entry.Type = DocumentType(documentType)
+	if year != 0 {
+		entry.Period = Period{Year: year, Month: time.Month(month)}.Key()
+	}
+	if corrYear != 0 {
+		entry.CorrectedPeriod = Period{Year: corrYear, Month: time.Month(corrMonth)}.Key()
+	}
+	if err := json.Unmarshal([]byte(fields), &entry.Fields); err != nil {
+		return entry, fmt.Errorf("failed to decode fields of %s: %v", entry.Hash, err)
+	}
+	return entry, nil
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of a file's content
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// documentLinkID derives a stable identifier from a download link, preferring
+// the document ID query parameter over the full link
+func documentLinkID(link string) string {
+	u, err := url.Parse(link)
+	if err != nil {
+		return link
+	}
+	query := u.Query()
+	for _, key := range sortedKeys(query) {
+		if isDocumentIDKey(key) && len(query[key]) > 0 {
+			return query[key][0]
+		}
+	}
+	return u.RequestURI()
+}
+
+// documentIDKeys lists the query parameters known to hold a document ID, in lower case
+var documentIDKeys = []string{"id", "docid", "doc_id", "documentid", "document_id", "fileid", "file_id"}
+
+func isDocumentIDKey(key string) bool {
+	for _, k := range documentIDKeys {
+		if strings.EqualFold(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// documentFields returns the fields extracted from a document as strings
+func documentFields(doc Document) map[string]string {
+	fields := make(map[string]string)
+	switch doc.Type {
+	case DocumentTypePayslip:
+		if payslip, err := parsePayslip(doc); err == nil {
+			for name, amount := range payslip.Fields() {
+				fields[name] = amount.String()
+			}
+			if payslip.TaxClass != "" {
+				fields["tax_class"] = payslip.TaxClass
+			}
+		}
+	case DocumentTypeTaxCertificate:
+		if certificate, err := parseTaxCertificate(doc); err == nil {
+			for name, amount := range certificate.Fields() {
+				fields[name] = amount.String()
+			}
+		}
+	case DocumentTypeSocialInsurance:
+		if from, to, ok := parseEmploymentPeriod(doc); ok {
+			fields["employment_from"] = from.Key()
+			fields["employment_to"] = to.Key()
+		}
+	}
+	if employer := parseEmployer(doc.Text); employer != "" {
+		fields["employer"] = employer
+	}
+	return fields
+}
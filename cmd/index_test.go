@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDocumentLinkID(t *testing.T) {
+	tests := []struct {
+		link string
+		want string
+	}{
+		{"https://adpworld.adp.com/AdpwAdpaWeb/DocDownload?docId=42&width=800", "42"},
+		{"/AdpwAdpaWeb/DocDownload?ID=7", "7"},
+		{"/AdpwAdpaWeb/DocDownload?document_id=abc&hidden=true", "abc"},
+		// Keys merely containing "id" are not document IDs
+		{"/AdpwAdpaWeb/DocDownload?width=800&guid=x&hidden=1", "/AdpwAdpaWeb/DocDownload?width=800&guid=x&hidden=1"},
+		{"/AdpwAdpaWeb/DocDownload", "/AdpwAdpaWeb/DocDownload"},
+	}
+	for _, tt := range tests {
+		if got := documentLinkID(tt.link); got != tt.want {
+			t.Errorf("documentLinkID(%q) = %q, want %q", tt.link, got, tt.want)
+		}
+	}
+}
+
+func TestOpenIndexReadOnly(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "adp", "index.db")
+
+	if _, err := OpenIndexReadOnly(path); !errors.Is(err, errNoIndex) {
+		t.Fatalf("OpenIndexReadOnly on missing index: err = %v, want errNoIndex", err)
+	}
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		t.Fatalf("OpenIndexReadOnly created %s", filepath.Dir(path))
+	}
+
+	index, err := OpenIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := index.RecordDownload("abc", "/DocDownload?docId=1", "/tmp/adp_1.pdf"); err != nil {
+		t.Fatal(err)
+	}
+	index.Close()
+
+	index, err = OpenIndexReadOnly(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+	entries, err := index.List(IndexFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].LinkID != "1" {
+		t.Errorf("entries = %+v, want one with link ID 1", entries)
+	}
+	if err := index.RecordDownload("def", "/DocDownload?docId=2", "/tmp/adp_2.pdf"); err == nil {
+		t.Error("RecordDownload on read-only index succeeded")
+	}
+}
+
+func TestOpenIndexRollsBackFailedMigration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+	index, err := OpenIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Close()
+
+	// A migration failing after its first statement
+	migrations := indexMigrations
+	defer func() { indexMigrations = migrations }()
+	indexMigrations = append(migrations[:len(migrations):len(migrations)],
+		`ALTER TABLE documents ADD COLUMN partial TEXT NOT NULL DEFAULT ''; SELECT * FROM missing;`)
+
+	if _, err := OpenIndex(path); err == nil {
+		t.Fatal("OpenIndex applied a failing migration")
+	}
+
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("user_version = %d, want %d", version, len(migrations))
+	}
+	if _, err := db.Exec(`SELECT partial FROM documents`); err == nil {
+		t.Error("the failed migration's first statement was kept")
+	}
+}
+
+func TestOpenIndexIsPrivate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "adp")
+	path := filepath.Join(dir, "index.db")
+
+	index, err := OpenIndex(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index.Close()
+
+	for name, want := range map[string]os.FileMode{dir: 0700, path: 0600} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != want {
+			t.Errorf("%s has mode %v, want %v", name, info.Mode().Perm(), want)
+		}
+	}
+
+	// Indexes created with the umask are restricted when opened
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if index, err = OpenIndex(path); err != nil {
+		t.Fatal(err)
+	}
+	index.Close()
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("reopened index has mode %v, want 0600 (%v)", info.Mode().Perm(), err)
+	}
+}
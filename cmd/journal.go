@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Journal records the renames of one process run so that the run can be undone
+type Journal struct {
+	RunID     string         `json:"run_id"`
+	StartedAt string         `json:"started_at"`
+	Paths     []string       `json:"paths"`
+	Renames   []JournalEntry `json:"renames"`
+	UndoneAt  string         `json:"undone_at,omitempty"`
+}
+
+// JournalEntry is a single rename
+type JournalEntry struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// OriginalHash is the content hash before the run
+	OriginalHash string `json:"original_hash"`
+	// Hash is the content hash after the run, which differs if metadata was embedded
+	Hash string `json:"hash"`
+	// Sidecar is the sidecar written next to the renamed file, if any
+	Sidecar string `json:"sidecar,omitempty"`
+	// Decrypted is the decrypted copy written next to the renamed file, if any
+	Decrypted string `json:"decrypted,omitempty"`
+	// Backup is the copy of the encrypted original replaced by its decrypted
+	// content, if any
+	Backup string `json:"backup,omitempty"`
+	// EmbeddedMetadata is set if the run embedded metadata into the file
+	EmbeddedMetadata bool `json:"embedded_metadata,omitempty"`
+	// Restored is set once undo has moved the file back to its original name
+	Restored bool `json:"restored,omitempty"`
+}
+
+// newJournal starts the journal of a process run
+func newJournal(pdfPaths []string) *Journal {
+	now := time.Now()
+	return &Journal{
+		RunID:     now.Format("20060102-150405.000"),
+		StartedAt: now.UTC().Format(time.RFC3339),
+		Paths:     pdfPaths,
+	}
+}
+
+// journalPath returns the location of a run's journal
+func journalPath(dir, runID string) string {
+	return filepath.Join(dir, runID+".json")
+}
+
+// Save writes the journal to dir
+func (j *Journal) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create journal directory: %v", err)
+	}
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode journal: %v", err)
+	}
+	return os.WriteFile(journalPath(dir, j.RunID), append(data, '\n'), 0644)
+}
+
+// readJournal reads the journal of a run
+func readJournal(dir, runID string) (*Journal, error) {
+	data, err := os.ReadFile(journalPath(dir, runID))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no journal for run %s", runID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var journal Journal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil, fmt.Errorf("failed to decode journal %s: %v", runID, err)
+	}
+	return &journal, nil
+}
+
+// listJournals returns all journals in dir, oldest first
+func listJournals(dir string) ([]*Journal, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var journals []*Journal
+	for _, file := range files {
+		journal, err := readJournal(dir, strings.TrimSuffix(filepath.Base(file), ".json"))
+		if err != nil {
+			return nil, err
+		}
+		journals = append(journals, journal)
+	}
+	return journals, nil
+}
+
+// checkUndo verifies that every renamed file not yet restored is still where
+// the run left it, unchanged, and that its original name is free again
+func (j *Journal) checkUndo() error {
+	// Names freed by reverting other renames of the same run
+	freed := make(map[string]bool)
+	for _, entry := range j.Renames {
+		if !entry.Restored {
+			freed[entry.To] = true
+		}
+	}
+
+	var problems []string
+	for _, entry := range j.Renames {
+		// Restored by an earlier, interrupted undo
+		if entry.Restored {
+			continue
+		}
+		hash, err := fileSHA256(entry.To)
+		switch {
+		case os.IsNotExist(err):
+			problems = append(problems, fmt.Sprintf("%s no longer exists", entry.To))
+			continue
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", entry.To, err))
+			continue
+		case hash != entry.Hash:
+			problems = append(problems, fmt.Sprintf("%s has changed since the run", entry.To))
+		}
+		if _, err := os.Stat(entry.From); err == nil && !freed[entry.From] {
+			problems = append(problems, fmt.Sprintf("%s already exists", entry.From))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("refusing to undo run %s:\n  %s", j.RunID, strings.Join(problems, "\n  "))
+	}
+	return nil
+}
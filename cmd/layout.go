@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/ledongthuc/pdf"
+)
+
+// Extractors select the text a rule is applied to
+const (
+	// extractorPlain searches the label's line of text, see documentLines
+	extractorPlain = ""
+	// extractorLayout uses the lines and cells reconstructed by extractLayout
+	extractorLayout = "layout"
+)
+
+// Layout is the text of a PDF reconstructed from glyph positions
+type Layout struct {
+	Lines []LayoutLine
+}
+
+// LayoutLine is a row of text, split into cells at wide gaps
+type LayoutLine struct {
+	Page int
+	// Y is the baseline, increasing from bottom to top
+	Y     float64
+	Cells []LayoutCell
+}
+
+// LayoutCell is a run of text without wide gaps, e.g. a label or an amount column
+type LayoutCell struct {
+	// X and Right delimit the cell horizontally
+	X, Right float64
+	Text     string
+}
+
+// Gaps between glyphs, relative to the font size, that separate words and cells
+const (
+	layoutWordGap = 0.15
+	layoutCellGap = 1.0
+)
+
+// extractLayout reconstructs the lines and cells of a PDF from its glyph positions
+func extractLayout(path string) (layout *Layout, err error) {
+	f, r, err := pdf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// The PDF library panics on some malformed content streams
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			layout, err = nil, fmt.Errorf("failed to read page content: %v", recovered)
+		}
+	}()
+
+	layout = &Layout{}
+	for i := 1; i <= r.NumPage(); i++ {
+		page := r.Page(i)
+		if page.V.IsNull() {
+			continue
+		}
+		layout.Lines = append(layout.Lines, layoutLines(i, page.Content().Text)...)
+	}
+	return layout, nil
+}
+
+// layoutLines groups the glyphs of a page into lines, top to bottom, and cells, left to right
+func layoutLines(page int, glyphs []pdf.Text) []LayoutLine {
+	glyphs = append([]pdf.Text(nil), glyphs...)
+	sort.SliceStable(glyphs, func(i, j int) bool {
+		return glyphs[i].Y > glyphs[j].Y
+	})
+
+	// Glyphs within half a font size of the line's baseline belong to it
+	var rows [][]pdf.Text
+	var baseline float64
+	for _, glyph := range glyphs {
+		size := math.Max(glyph.FontSize, 1)
+		if len(rows) == 0 || math.Abs(glyph.Y-baseline) > size/2 {
+			// Spaces don't start a line of their own
+			if strings.TrimSpace(glyph.S) == "" {
+				continue
+			}
+			rows = append(rows, nil)
+			baseline = glyph.Y
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], glyph)
+	}
+
+	lines := make([]LayoutLine, 0, len(rows))
+	for _, row := range rows {
+		sort.SliceStable(row, func(i, j int) bool {
+			return row[i].X < row[j].X
+		})
+
+		line := LayoutLine{Page: page, Y: row[0].Y}
+		var text strings.Builder
+		var cell *LayoutCell
+		closeCell := func() {
+			if cell != nil {
+				cell.Text = text.String()
+				line.Cells = append(line.Cells, *cell)
+				cell = nil
+			}
+		}
+
+		space := false
+		for _, glyph := range row {
+			// Spaces separate words; wide gaps separate cells
+			if strings.TrimSpace(glyph.S) == "" {
+				space = cell != nil
+				continue
+			}
+			size := math.Max(glyph.FontSize, 1)
+			if cell != nil && glyph.X-cell.Right > size*layoutCellGap {
+				closeCell()
+			}
+			if cell == nil {
+				cell = &LayoutCell{X: glyph.X}
+				text.Reset()
+			} else if space || glyph.X-cell.Right > size*layoutWordGap {
+				text.WriteByte(' ')
+			}
+			space = false
+			text.WriteString(glyph.S)
+			cell.Right = glyph.X + glyph.W
+		}
+		closeCell()
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// String renders the layout as text with one line per row and cells separated by tabs
+func (l *Layout) String() string {
+	var b strings.Builder
+	for _, line := range l.Lines {
+		for i, cell := range line.Cells {
+			if i > 0 {
+				b.WriteByte('\t')
+			}
+			b.WriteString(cell.Text)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// Value returns the submatches of the first value following a label: in the rest
+// of the label's cell, in the cells to its right, or in the overlapping cells of
+// the line below. Returns nil if the label or a value isn't found.
+func (l *Layout) Value(label, value *regexp.Regexp) []string {
+	for i, line := range l.Lines {
+		for j, cell := range line.Cells {
+			loc := label.FindStringIndex(cell.Text)
+			if loc == nil {
+				continue
+			}
+
+			// Same cell and cells to the right
+			if matches := value.FindStringSubmatch(cell.Text[loc[1]:]); matches != nil {
+				return matches
+			}
+			for _, right := range line.Cells[j+1:] {
+				if matches := value.FindStringSubmatch(right.Text); matches != nil {
+					return matches
+				}
+			}
+
+			// Cells of the next line on the same page that overlap the label
+			if i+1 < len(l.Lines) && l.Lines[i+1].Page == line.Page {
+				for _, below := range l.Lines[i+1].Cells {
+					if below.X <= cell.Right && below.Right >= cell.X {
+						if matches := value.FindStringSubmatch(below.Text); matches != nil {
+							return matches
+						}
+					}
+				}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// Amount returns the first amount formatted in the locale following a label
+func (l *Layout) Amount(label *regexp.Regexp, locale Locale) (Money, bool) {
+	matches := l.Value(label, locale.Amount)
+	if matches == nil {
+		return 0, false
+	}
+	amount, err := locale.parseAmount(matches[0])
+	if err != nil {
+		return 0, false
+	}
+	return amount, true
+}
+
+// lazyLayout returns a function that extracts the document's layout on first
+// use, so that documents without layout rules are only parsed once. The
+// function returns nil if the layout can't be extracted.
+func lazyLayout(doc Document) func() *Layout {
+	var layout *Layout
+	loaded := false
+	return func() *Layout {
+		if !loaded {
+			loaded = true
+			var err error
+			if layout, err = extractLayout(doc.Path); err != nil {
+				log.Debug("Failed to extract layout", "filename", doc.Path, "error", err)
+			}
+		}
+		return layout
+	}
+}
+
+// documentLines returns the text of a document with one line per row of the
+// layout and cells separated by tabs. The plain text only breaks lines where
+// the PDF moves to a new line with T*, so labels and amounts of a whole page
+// often end up on one line. Falls back to the plain text if the layout has no
+// text, e.g. for scanned PDFs.
+func documentLines(doc Document, layout func() *Layout) string {
+	if l := layout(); l != nil && len(l.Lines) > 0 {
+		return l.String()
+	}
+	return doc.Text
+}
+
+// findRuleAmount applies an amount rule to the text selected by its extractor,
+// falling back to the label's line in lines if the layout doesn't yield a value
+func findRuleAmount(lines string, layout func() *Layout, extractor string, label *regexp.Regexp, locale Locale) (Money, bool) {
+	if extractor == extractorLayout {
+		if l := layout(); l != nil {
+			if amount, ok := l.Amount(label, locale); ok {
+				return amount, true
+			}
+		}
+	}
+	return findAmount(lines, label, locale)
+}
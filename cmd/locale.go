@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Locale holds the texts by which the ADP portal and its documents are
+// recognized in one language. Documents are named in German regardless of
+// their language, so that an archive keeps one naming scheme.
+type Locale struct {
+	// Name selects the locale with --locale
+	Name string
+	// Language is requested from the portal by the browser, e.g. "de-DE"
+	Language string
+
+	// AllDocuments labels the dashboard button leading to the document list,
+	// followed by the number of documents in parentheses
+	AllDocuments string
+	// NextPage is the aria-label of the document list's next page link
+	NextPage string
+
+	// MonthNames maps months to their names in documents
+	MonthNames map[time.Month]string
+	// TaxCertificate matches tax certificates and captures the year
+	TaxCertificate *regexp.Regexp
+	// SocialInsurance matches social insurance certificates
+	SocialInsurance *regexp.Regexp
+	// Payslip matches payslips
+	Payslip *regexp.Regexp
+	// Correction matches Rückrechnungen and captures the corrected month and year
+	Correction *regexp.Regexp
+	// Period matches the Abrechnungsmonat and captures month and year
+	Period *regexp.Regexp
+	// PeriodLabel matches the label of the Abrechnungsmonat, looked up separately in the layout
+	PeriodLabel *regexp.Regexp
+
+	// DecimalSeparator and GroupSeparator format amounts in documents
+	DecimalSeparator string
+	GroupSeparator   string
+	// Amount matches amounts formatted with the separators, e.g. "1.234,56" or "12,00-"
+	Amount *regexp.Regexp
+	// WageType matches Lohnart lines and captures code, name and amount
+	WageType *regexp.Regexp
+	// PayslipLabels maps the names of payslipFields to their labels
+	PayslipLabels map[string]*regexp.Regexp
+	// TaxCertificateLabels maps the names of taxCertificateFields to their labels
+	TaxCertificateLabels map[string]*regexp.Regexp
+}
+
+// localeNames lists the values of --locale: auto detects the language
+var localeNames = []string{"auto", "de", "en"}
+
+// locales lists the supported languages, in the order they are tried
+var locales = []Locale{germanLocale, englishLocale}
+
+var germanLocale = Locale{
+	Name:         "de",
+	Language:     "de-DE",
+	AllDocuments: "Alle Dokumente",
+	NextPage:     "Nächste Seite",
+	MonthNames: map[time.Month]string{
+		time.January:   "Januar",
+		time.February:  "Februar",
+		time.March:     "März",
+		time.April:     "April",
+		time.May:       "Mai",
+		time.June:      "Juni",
+		time.July:      "Juli",
+		time.August:    "August",
+		time.September: "September",
+		time.October:   "Oktober",
+		time.November:  "November",
+		time.December:  "Dezember",
+	},
+	TaxCertificate:  regexp.MustCompile(`Ausdruck der elektronischen Lohnsteuerbescheinigung für (\d{4})`),
+	SocialInsurance: regexp.MustCompile(`Meldebescheinigung zur Sozialversicherung`),
+	Payslip:         regexp.MustCompile(`Verdienstabrechnung`),
+	Correction:      regexp.MustCompile(`Rückrechnung:?\s*([A-Za-zäöüÄÖÜß]+)\s+(\d{4})`),
+	Period:          regexp.MustCompile(`Abrechnungsmonat:?\s*([A-Za-zäöüÄÖÜß]+)\s+(\d{4})`),
+	PeriodLabel:     regexp.MustCompile(`Abrechnungsmonat:?`),
+
+	DecimalSeparator: ",",
+	GroupSeparator:   ".",
+	Amount:           regexp.MustCompile(amountPattern(",", ".")),
+	WageType:         regexp.MustCompile(wageTypePattern(amountPattern(",", "."))),
+	PayslipLabels: map[string]*regexp.Regexp{
+		"gross":                  regexp.MustCompile(`Gesamt-?\s?[Bb]rutto`),
+		"taxable_gross":          regexp.MustCompile(`Steuer-?\s?[Bb]rutto|St\.-?\s?[Bb]rutto|[Ss]teuerpflichtiges Brutto`),
+		"income_tax":             regexp.MustCompile(`Lohnsteuer\b`),
+		"solidarity_surcharge":   regexp.MustCompile(`Solidarit(?:ä|ae)tszuschlag`),
+		"church_tax":             regexp.MustCompile(`Kirchensteuer`),
+		"health_insurance":       regexp.MustCompile(`Krankenversicherung`),
+		"pension_insurance":      regexp.MustCompile(`Rentenversicherung`),
+		"unemployment_insurance": regexp.MustCompile(`Arbeitslosenversicherung`),
+		"care_insurance":         regexp.MustCompile(`Pflegeversicherung`),
+		"net":                    regexp.MustCompile(`Netto-?\s?(?:[Vv]erdienst|[Bb]ezug)`),
+		"payout":                 regexp.MustCompile(`Auszahlungsbetrag|Überweisung`),
+	},
+	TaxCertificateLabels: map[string]*regexp.Regexp{
+		"gross_wage":                 regexp.MustCompile(`\b3\.\s*Bruttoarbeitslohn`),
+		"income_tax":                 regexp.MustCompile(`\b4\.\s*Einbehaltene Lohnsteuer`),
+		"solidarity_surcharge":       regexp.MustCompile(`\b5\.\s*Einbehaltener Solidarit(?:ä|ae)tszuschlag`),
+		"church_tax":                 regexp.MustCompile(`\b6\.\s*Einbehaltene Kirchensteuer des Arbeitnehmers`),
+		"employer_pension_insurance": regexp.MustCompile(`\b22\.?\s*a\)?\s*[^\n]*?Rentenversicherung|Arbeitgeberanteil[^\n]*?gesetzlichen Rentenversicherung`),
+		"pension_insurance":          regexp.MustCompile(`\b23\.?\s*a\)?\s*[^\n]*?Rentenversicherung|Arbeitnehmeranteil[^\n]*?gesetzlichen Rentenversicherung`),
+		"health_insurance":           regexp.MustCompile(`\b25\.\s*Arbeitnehmerbeiträge zur gesetzlichen Krankenversicherung`),
+		"care_insurance":             regexp.MustCompile(`\b26\.\s*Arbeitnehmerbeiträge zur sozialen Pflegeversicherung`),
+		"unemployment_insurance":     regexp.MustCompile(`\b27\.\s*Arbeitnehmerbeiträge zur Arbeitslosenversicherung`),
+	},
+}
+
+var englishLocale = Locale{
+	Name:         "en",
+	Language:     "en-US",
+	AllDocuments: "All Documents",
+	NextPage:     "Next Page",
+	MonthNames: map[time.Month]string{
+		time.January:   "January",
+		time.February:  "February",
+		time.March:     "March",
+		time.April:     "April",
+		time.May:       "May",
+		time.June:      "June",
+		time.July:      "July",
+		time.August:    "August",
+		time.September: "September",
+		time.October:   "October",
+		time.November:  "November",
+		time.December:  "December",
+	},
+	TaxCertificate:  regexp.MustCompile(`(?i)Printout of the electronic (?:wage|income) tax certificate for (\d{4})`),
+	SocialInsurance: regexp.MustCompile(`(?i)(?:Notification|Certificate) (?:of|for|to) (?:the )?social insurance|Social insurance (?:notification|certificate)`),
+	Payslip:         regexp.MustCompile(`(?i)Pay ?slip|(?:Earnings|Pay) statement`),
+	Correction:      regexp.MustCompile(`(?i)(?:Retroactive (?:calculation|accounting)|Recalculation):?\s*([A-Za-z]+)\s+(\d{4})`),
+	Period:          regexp.MustCompile(`(?i)(?:Payroll|Pay|Accounting|Billing) (?:period|month):?\s*([A-Za-z]+)\s+(\d{4})`),
+	PeriodLabel:     regexp.MustCompile(`(?i)(?:Payroll|Pay|Accounting|Billing) (?:period|month):?`),
+
+	DecimalSeparator: ".",
+	GroupSeparator:   ",",
+	Amount:           regexp.MustCompile(amountPattern(".", ",")),
+	WageType:         regexp.MustCompile(wageTypePattern(amountPattern(".", ","))),
+	PayslipLabels: map[string]*regexp.Regexp{
+		"gross":                  regexp.MustCompile(`(?i)Total gross`),
+		"taxable_gross":          regexp.MustCompile(`(?i)Taxable gross|Tax gross`),
+		"income_tax":             regexp.MustCompile(`(?i)Income tax\b`),
+		"solidarity_surcharge":   regexp.MustCompile(`(?i)Solidarity surcharge`),
+		"church_tax":             regexp.MustCompile(`(?i)Church tax`),
+		"health_insurance":       regexp.MustCompile(`(?i)Health insurance`),
+		"pension_insurance":      regexp.MustCompile(`(?i)Pension insurance`),
+		"unemployment_insurance": regexp.MustCompile(`(?i)Unemployment insurance`),
+		"care_insurance":         regexp.MustCompile(`(?i)(?:Long-term )?care insurance`),
+		"net":                    regexp.MustCompile(`(?i)Net (?:pay|earnings|salary)`),
+		"payout":                 regexp.MustCompile(`(?i)Payout|Payment amount|Bank transfer`),
+	},
+	TaxCertificateLabels: map[string]*regexp.Regexp{
+		"gross_wage":                 regexp.MustCompile(`(?i)\b3\.\s*Gross wages?`),
+		"income_tax":                 regexp.MustCompile(`(?i)\b4\.\s*(?:Withheld )?income tax`),
+		"solidarity_surcharge":       regexp.MustCompile(`(?i)\b5\.\s*(?:Withheld )?solidarity surcharge`),
+		"church_tax":                 regexp.MustCompile(`(?i)\b6\.\s*(?:Withheld )?church tax`),
+		"employer_pension_insurance": regexp.MustCompile(`(?i)\b22\.?\s*a\)?\s*[^\n]*?pension insurance`),
+		"pension_insurance":          regexp.MustCompile(`(?i)\b23\.?\s*a\)?\s*[^\n]*?pension insurance`),
+		"health_insurance":           regexp.MustCompile(`(?i)\b25\.\s*Employee contributions to (?:the )?statutory health insurance`),
+		"care_insurance":             regexp.MustCompile(`(?i)\b26\.\s*Employee contributions to (?:the )?(?:social )?(?:long-term )?care insurance`),
+		"unemployment_insurance":     regexp.MustCompile(`(?i)\b27\.\s*Employee contributions to (?:the )?unemployment insurance`),
+	},
+}
+
+// periodValueRegex matches a month name and year following a PeriodLabel
+var periodValueRegex = regexp.MustCompile(`([A-Za-zäöüÄÖÜß]+)\s+(\d{4})`)
+
+// amountPattern matches amounts with the given separators, e.g. "1.234,56",
+// "4500,00" or "12,00-" for German documents
+func amountPattern(decimal, group string) string {
+	return `-?(?:\d{1,3}(?:` + regexp.QuoteMeta(group) + `\d{3})+|\d+)` + regexp.QuoteMeta(decimal) + `\d{2}\b-?`
+}
+
+// wageTypePattern matches Lohnart lines ending in an amount, e.g. "1000 Gehalt 5.000,00"
+func wageTypePattern(amountPattern string) string {
+	return `(?m)^\s*(\d{3,4})\s+([A-Za-zÄÖÜäöüß][^\n\d]*?)\s+(` + amountPattern + `)\s*$`
+}
+
+// parseAmount parses an amount formatted in this locale into cents
+func (l Locale) parseAmount(s string) (Money, error) {
+	s = strings.TrimSpace(s)
+	negative := false
+	if strings.HasPrefix(s, "-") {
+		negative = true
+		s = s[1:]
+	}
+	if strings.HasSuffix(s, "-") {
+		negative = true
+		s = s[:len(s)-1]
+	}
+	s = strings.ReplaceAll(s, l.GroupSeparator, "")
+	s = strings.Replace(s, l.DecimalSeparator, "", 1)
+
+	cents, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q: %v", s, err)
+	}
+	if negative {
+		cents = -cents
+	}
+	return Money(cents), nil
+}
+
+// AllDocumentsPattern matches the AllDocuments button text including the number of documents
+func (l Locale) AllDocumentsPattern() string {
+	return regexp.QuoteMeta(l.AllDocuments) + `\s*\(\d+\)`
+}
+
+// matches reports whether the text is a document of a known type in this locale
+func (l Locale) matches(text string) bool {
+	return l.TaxCertificate.MatchString(text) || l.SocialInsurance.MatchString(text) || l.Payslip.MatchString(text)
+}
+
+// selectLocales returns the locales to try for a --locale value: all of them
+// for auto, or the named one
+func selectLocales(name string) ([]Locale, error) {
+	if name == "auto" {
+		return locales, nil
+	}
+	for _, locale := range locales {
+		if locale.Name == name {
+			return []Locale{locale}, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported locale %q (supported: %s)", name, strings.Join(localeNames, ", "))
+}
+
+// documentLocale returns the locale a document was classified in. Documents
+// without one are detected from their text, falling back to German.
+func documentLocale(doc Document) Locale {
+	for _, locale := range locales {
+		if locale.Name == doc.Locale {
+			return locale
+		}
+	}
+	for _, locale := range locales {
+		if locale.matches(doc.Text) {
+			return locale
+		}
+	}
+	return germanLocale
+}
+
+// hasLocale reports whether a locale name is empty or among the candidates,
+// where no candidates stand for all locales
+func hasLocale(candidates []Locale, name string) bool {
+	if name == "" || len(candidates) == 0 {
+		return true
+	}
+	for _, locale := range candidates {
+		if locale.Name == name {
+			return true
+		}
+	}
+	return false
+}
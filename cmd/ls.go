@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// documentTypeNames maps command line names to document types
+var documentTypeNames = map[string]DocumentType{
+	"payslip":          DocumentTypePayslip,
+	"tax-certificate":  DocumentTypeTaxCertificate,
+	"social-insurance": DocumentTypeSocialInsurance,
+}
+
+// lsFormats lists the supported output formats of the ls command
+var lsFormats = []string{"table", "json", "tsv"}
+
+// NewLsCmd creates and configures the ls command
+func NewLsCmd(config Config) *cobra.Command {
+	var (
+		indexPath    string
+		documentType string
+		year         int
+		correction   bool
+		format       string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "ls",
+		Short: "List indexed documents",
+		Long: `List the documents recorded in the index maintained by download and process.
+The json and tsv formats are stable and meant for scripts.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			filter := IndexFilter{Year: year, Correction: correction}
+			if documentType != "" {
+				t, ok := documentTypeNames[documentType]
+				if !ok {
+					log.Error("Unknown document type", "type", documentType, "supported", sortedKeys(documentTypeNames))
+					os.Exit(1)
+				}
+				filter.Type = t
+			}
+
+			index, err := OpenIndexReadOnly(indexPath)
+			if errors.Is(err, errNoIndex) {
+				log.Error("No index found", "path", indexPath, "hint", "run download or process first")
+				os.Exit(1)
+			}
+			if err != nil {
+				log.Error("Error opening index", "error", err)
+				os.Exit(1)
+			}
+			defer index.Close()
+
+			entries, err := index.List(filter)
+			if err != nil {
+				log.Error("Error listing documents", "error", err)
+				os.Exit(1)
+			}
+
+			if err := writeIndexEntries(os.Stdout, format, entries); err != nil {
+				log.Error("Error writing documents", "error", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&indexPath, "index", filepath.Join(config.DataDir, "index.db"), "Path to the document index")
+	cmd.Flags().StringVar(&documentType, "type", "", "Only list documents of this type (payslip, tax-certificate, social-insurance)")
+	cmd.Flags().IntVar(&year, "year", 0, "Only list documents of this year")
+	cmd.Flags().BoolVar(&correction, "correction", false, "Only list Rückrechnung payslips")
+	cmd.Flags().StringVar(&format, "output", "table", "Output format (table, json, tsv)")
+
+	return cmd
+}
+
+// writeIndexEntries writes index entries in the given format
+func writeIndexEntries(w io.Writer, format string, entries []IndexEntry) error {
+	switch format {
+	case "json":
+		if entries == nil {
+			entries = []IndexEntry{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(entries)
+	case "tsv":
+		fmt.Fprintln(w, strings.Join([]string{"hash", "type", "period", "corrected_period", "correction_number", "link_id", "path"}, "\t"))
+		for _, entry := range entries {
+			if _, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+				entry.Hash, entry.Type, entry.Period, entry.CorrectedPeriod,
+				entry.CorrectionNumber, entry.LinkID, entry.Path); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "table":
+		table := reportTable{Header: []string{"Period", "Type", "Corrects", "File"}}
+		for _, entry := range entries {
+			documentType := string(entry.Type)
+			if documentType == "" {
+				documentType = "(unprocessed)"
+			}
+			table.Rows = append(table.Rows, []string{entry.Period, documentType, entry.CorrectedPeriod, filepath.Base(entry.Path)})
+		}
+		return renderReportRows(w, table)
+	default:
+		return fmt.Errorf("unsupported format: %s (supported: %s)", format, strings.Join(lsFormats, ", "))
+	}
+}
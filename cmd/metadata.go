@@ -0,0 +1,285 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// Regexes to extract the employer's name, e.g. "Arbeitgeber: Example GmbH" on payslips or the
+// line following "Anschrift und Steuernummer des Arbeitgebers" on tax certificates
+var employerRegexes = []*regexp.Regexp{
+	regexp.MustCompile(`(?m)^[ \t]*(?:Arbeitgeber|Firma|(?i:Employer|Company))[ \t]*:[ \t]*(\S[^\n]*?)[ \t]*$`),
+	regexp.MustCompile(`Anschrift(?: und Steuernummer)? des Arbeitgebers:?[ \t]*\n\s*(\S[^\n]*?)[ \t]*\n`),
+}
+
+// parseEmployer returns the employer named in a document, if any
+func parseEmployer(text string) string {
+	for _, regex := range employerRegexes {
+		if matches := regex.FindStringSubmatch(text); len(matches) > 1 {
+			return matches[1]
+		}
+	}
+	return ""
+}
+
+// pdfMetadata returns the document info entries describing a classified document.
+// Title, Subject and Keywords are shown by desktop search and document management
+// tools; the ADP* entries carry the classification in machine-readable form.
+func pdfMetadata(doc Document, fields map[string]string) map[string]string {
+	employer := fields["employer"]
+
+	subject := fmt.Sprintf("%s %s", doc.Type, doc.Period)
+	keywords := []string{string(doc.Type), doc.Period.Key()}
+	if doc.Correction != nil {
+		subject += fmt.Sprintf(" (Rückrechnung für %s)", doc.Correction)
+		keywords = append(keywords, "Rückrechnung", doc.Correction.Key())
+	}
+	if employer != "" {
+		subject += ", " + employer
+		keywords = append(keywords, employer)
+	}
+
+	properties := map[string]string{
+		"Title":     strings.TrimSuffix(doc.Filename(), ".pdf"),
+		"Subject":   subject,
+		"Keywords":  strings.Join(keywords, "; "),
+		"ADPType":   string(doc.Type),
+		"ADPPeriod": doc.Period.Key(),
+	}
+	if doc.Correction != nil {
+		properties["ADPCorrectedPeriod"] = doc.Correction.Key()
+		properties["ADPCorrectionNumber"] = strconv.Itoa(doc.CorrectionNumber)
+	}
+	if employer != "" {
+		properties["ADPEmployer"] = employer
+	}
+	return properties
+}
+
+// embedMetadata writes the properties into the PDF's document info dictionary and,
+// if the catalog references one, its XMP metadata stream, which takes precedence
+// in most viewers. They are appended as an incremental update, so the original
+// content stays intact. The file is left untouched if it already carries the
+// same values, so that repeated runs don't change its content hash. Reports
+// whether the file was updated.
+func embedMetadata(path string, properties map[string]string) (bool, error) {
+	// Don't create a pdfcpu configuration directory in the user's home
+	api.DisableConfigDir()
+
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	conf.Cmd = model.ADDPROPERTIES
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	ctx, err := api.ReadAndValidate(f, conf)
+	f.Close()
+	if err != nil {
+		return false, fmt.Errorf("failed to read PDF: %v", err)
+	}
+
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return false, fmt.Errorf("failed to read PDF catalog: %v", err)
+	}
+	var metadata *types.StreamDict
+	var metadataRef types.IndirectRef
+	var xmp []byte
+	if ref, ok := catalog["Metadata"].(types.IndirectRef); ok {
+		if metadata, _, err = ctx.DereferenceStreamDict(ref); err != nil {
+			return false, fmt.Errorf("failed to read XMP metadata: %v", err)
+		}
+		if metadata != nil {
+			if err := metadata.Decode(); err != nil {
+				return false, fmt.Errorf("failed to decode XMP metadata: %v", err)
+			}
+			if xmp, err = updateXMP(metadata.Content, properties); err != nil {
+				return false, err
+			}
+			metadataRef = ref
+		}
+	}
+	if hasProperties(ctx, properties) && (metadata == nil || bytes.Equal(xmp, metadata.Content)) {
+		return false, nil
+	}
+
+	ctx.Write.Increment = true
+	ctx.Write.Offset = ctx.Read.FileSize
+	// Append the same kind of cross-reference section the file already uses
+	ctx.WriteXRefStream = ctx.Read.UsingXRefStreams
+	ctx.WriteObjectStream = false
+
+	// PDF 2.0 files may lack a document info dictionary
+	if ctx.Info == nil {
+		if ctx.Info, err = ctx.IndRefForNewObject(types.Dict{}); err != nil {
+			return false, fmt.Errorf("failed to add document info: %v", err)
+		}
+	}
+	if err := pdfcpu.PropertiesAdd(ctx, properties); err != nil {
+		return false, fmt.Errorf("failed to add properties: %v", err)
+	}
+	ctx.Write.IncrementWithObjNr(ctx.Info.ObjectNumber.Value())
+	if metadata != nil {
+		metadata.Content = xmp
+		if err := metadata.Encode(); err != nil {
+			return false, fmt.Errorf("failed to encode XMP metadata: %v", err)
+		}
+		entry, ok := ctx.FindTableEntryForIndRef(&metadataRef)
+		if !ok {
+			return false, fmt.Errorf("failed to update XMP metadata: object %d not found", metadataRef.ObjectNumber.Value())
+		}
+		entry.Object = *metadata
+		ctx.Write.IncrementWithObjNr(metadataRef.ObjectNumber.Value())
+	}
+
+	// Append to a copy first so that a failure never leaves a damaged PDF
+	tmpPath := path + ".tmp"
+	if err := copyFile(path, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	out, err := os.OpenFile(tmpPath, os.O_RDWR, 0)
+	if err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	if err := api.WriteIncr(ctx, out, conf); err != nil {
+		out.Close()
+		os.Remove(tmpPath)
+		return false, fmt.Errorf("failed to write PDF: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return false, err
+	}
+	return true, nil
+}
+
+// adpNamespace is the XMP namespace of the ADP* properties
+const adpNamespace = "https://github.com/mamachanko/adp/ns/1.0/"
+
+var (
+	// Regex to find the rdf:Description written by updateXMP, including the line
+	// break inserted before it
+	xmpDescriptionRegex = regexp.MustCompile(`(?s)\n?<rdf:Description\b[^>]*xmlns:adp="` + regexp.QuoteMeta(adpNamespace) + `"[^>]*>.*?</rdf:Description>`)
+	// Regexes to find the XMP counterparts of Title, Subject and Keywords, as
+	// elements or attributes of other descriptions
+	xmpPropertyRegexes = []*regexp.Regexp{
+		regexp.MustCompile(`(?s)\s*<dc:title\b[^>]*?(?:/>|>.*?</dc:title>)`),
+		regexp.MustCompile(`(?s)\s*<dc:description\b[^>]*?(?:/>|>.*?</dc:description>)`),
+		regexp.MustCompile(`(?s)\s*<pdf:Keywords\b[^>]*?(?:/>|>.*?</pdf:Keywords>)`),
+		regexp.MustCompile(`\s(?:dc:title|dc:description|pdf:Keywords)="[^"]*"`),
+	}
+	// Regex to find the subject of the existing descriptions
+	xmpAboutRegex = regexp.MustCompile(`<rdf:Description\b[^>]*\brdf:about="([^"]*)"`)
+)
+
+// updateXMP returns the XMP metadata with Title, Subject and Keywords as
+// dc:title, dc:description and pdf:Keywords and the ADP* properties in their own
+// namespace. Earlier values are replaced and everything else is kept, e.g. the
+// PDF/A identification.
+func updateXMP(xmp []byte, properties map[string]string) ([]byte, error) {
+	text := xmpDescriptionRegex.ReplaceAllString(string(xmp), "")
+	for _, regex := range xmpPropertyRegexes {
+		text = regex.ReplaceAllString(text, "")
+	}
+	end := strings.LastIndex(text, "</rdf:RDF>")
+	if end < 0 {
+		return nil, fmt.Errorf("XMP metadata lacks an rdf:RDF element")
+	}
+
+	escape := func(s string) string {
+		var b strings.Builder
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+	about := ""
+	if matches := xmpAboutRegex.FindStringSubmatch(text); matches != nil {
+		about = matches[1]
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "\n<rdf:Description rdf:about=\"%s\" xmlns:dc=\"http://purl.org/dc/elements/1.1/\" xmlns:pdf=\"http://ns.adobe.com/pdf/1.3/\" xmlns:adp=\"%s\">\n", about, adpNamespace)
+	if title, ok := properties["Title"]; ok {
+		fmt.Fprintf(&b, "<dc:title><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:title>\n", escape(title))
+	}
+	if subject, ok := properties["Subject"]; ok {
+		fmt.Fprintf(&b, "<dc:description><rdf:Alt><rdf:li xml:lang=\"x-default\">%s</rdf:li></rdf:Alt></dc:description>\n", escape(subject))
+	}
+	if keywords, ok := properties["Keywords"]; ok {
+		fmt.Fprintf(&b, "<pdf:Keywords>%s</pdf:Keywords>\n", escape(keywords))
+	}
+	for _, key := range sortedKeys(properties) {
+		if name, ok := strings.CutPrefix(key, "ADP"); ok {
+			fmt.Fprintf(&b, "<adp:%s>%s</adp:%s>\n", name, escape(properties[key]), name)
+		}
+	}
+	b.WriteString("</rdf:Description>")
+
+	return []byte(text[:end] + b.String() + text[end:]), nil
+}
+
+// hasProperties reports whether the document info dictionary already contains the properties
+func hasProperties(ctx *model.Context, properties map[string]string) bool {
+	if ctx.Info == nil {
+		return false
+	}
+	d, err := ctx.DereferenceDict(*ctx.Info)
+	if err != nil || d == nil {
+		return false
+	}
+	for key, value := range properties {
+		entry, ok := d[key]
+		if !ok {
+			return false
+		}
+		if text, err := ctx.DereferenceText(entry); err != nil || text != value {
+			return false
+		}
+	}
+	return true
+}
+
+// copyFile copies the content of src to a new file dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+// testXMP is an XMP packet of a PDF/A document with a title
+const testXMP = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about="" xmlns:pdfaid="http://www.aiim.org/pdfa/ns/id/" pdfaid:part="2" pdfaid:conformance="B"/>
+<rdf:Description rdf:about="" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:xmp="http://ns.adobe.com/xap/1.0/">
+<dc:title><rdf:Alt><rdf:li xml:lang="x-default">Old title</rdf:li></rdf:Alt></dc:title>
+<xmp:CreatorTool>ADP</xmp:CreatorTool>
+</rdf:Description>
+</rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+// testPDF builds a minimal single page PDF without text whose catalog
+// references an XMP metadata stream
+func testPDF() []byte {
+	return buildTestPDF("1.7", testXMP)
+}
+
+// buildTestPDF builds a minimal single page PDF of a version without text or
+// document info, with an XMP metadata stream unless xmp is empty
+func buildTestPDF(version, xmp string) []byte {
+	catalog := "<< /Type /Catalog /Pages 2 0 R >>"
+	if xmp != "" {
+		catalog = "<< /Type /Catalog /Pages 2 0 R /Metadata 5 0 R >>"
+	}
+	objects := []string{
+		catalog,
+		"<< /Type /Pages /Kids [3 0 R] /Count 1 >>",
+		"<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Contents 4 0 R >>",
+		"<< /Length 0 >>\nstream\n\nendstream",
+	}
+	if xmp != "" {
+		objects = append(objects, fmt.Sprintf("<< /Type /Metadata /Subtype /XML /Length %d >>\nstream\n%s\nendstream", len(xmp), xmp))
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%%PDF-%s\n", version)
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return buf.Bytes()
+}
+
+func TestEmbedMetadata(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "2024-03_Verdienstabrechnung.pdf")
+	original := testPDF()
+	if err := os.WriteFile(path, original, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	properties := map[string]string{"Title": "2024-03_Verdienstabrechnung", "ADPType": "Verdienstabrechnung"}
+	changed, err := embedMetadata(path, properties)
+	if err != nil {
+		t.Fatalf("embedMetadata: %v", err)
+	}
+	if !changed {
+		t.Fatal("embedMetadata reported no change")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(data, original) {
+		t.Error("original content was rewritten instead of updated incrementally")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Errorf("mode = %v, want 0640", info.Mode().Perm())
+	}
+
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	ctx, err := api.ReadAndValidate(bytes.NewReader(data), conf)
+	if err != nil {
+		t.Fatalf("reading updated PDF: %v", err)
+	}
+	if !hasProperties(ctx, properties) {
+		t.Error("document info doesn't contain the properties")
+	}
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata, _, err := ctx.DereferenceStreamDict(catalog["Metadata"])
+	if err != nil || metadata == nil {
+		t.Fatalf("catalog doesn't reference the XMP metadata: %v", err)
+	}
+	if err := metadata.Decode(); err != nil {
+		t.Fatal(err)
+	}
+	xmp := string(metadata.Content)
+	for _, want := range []string{`pdfaid:part="2"`, "<xmp:CreatorTool>ADP</xmp:CreatorTool>",
+		`<rdf:li xml:lang="x-default">2024-03_Verdienstabrechnung</rdf:li>`, "<adp:Type>Verdienstabrechnung</adp:Type>"} {
+		if !strings.Contains(xmp, want) {
+			t.Errorf("XMP metadata lacks %s:\n%s", want, xmp)
+		}
+	}
+	if strings.Contains(xmp, "Old title") || strings.Count(xmp, "<dc:title>") != 1 {
+		t.Errorf("XMP metadata keeps the old title:\n%s", xmp)
+	}
+
+	// Embedding the same values again leaves the file untouched
+	changed, err = embedMetadata(path, properties)
+	if err != nil {
+		t.Fatalf("embedMetadata again: %v", err)
+	}
+	if changed {
+		t.Error("embedMetadata changed a file already carrying the properties")
+	}
+	again, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(again, data) {
+		t.Error("file content changed on the second run")
+	}
+	if strings.Count(string(again), "%%EOF") != 2 {
+		t.Errorf("want exactly one incremental update, got %d sections", strings.Count(string(again), "%%EOF"))
+	}
+}
+
+func TestEmbedMetadataWithoutInfo(t *testing.T) {
+	// PDF 2.0 files don't get a document info dictionary by default
+	path := filepath.Join(t.TempDir(), "scan.pdf")
+	if err := os.WriteFile(path, buildTestPDF("2.0", ""), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	properties := map[string]string{"Title": "2024-03_Verdienstabrechnung"}
+	if _, err := embedMetadata(path, properties); err != nil {
+		t.Fatalf("embedMetadata: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	ctx, err := api.ReadAndValidate(bytes.NewReader(data), conf)
+	if err != nil {
+		t.Fatalf("reading updated PDF: %v", err)
+	}
+	if !hasProperties(ctx, properties) {
+		t.Error("document info doesn't contain the properties")
+	}
+}
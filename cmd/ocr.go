@@ -0,0 +1,162 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/log"
+)
+
+// ocrEngines lists the OCR engines that can be selected: auto uses Tesseract if
+// it is installed, off disables OCR
+var ocrEngines = []string{"auto", "off", "tesseract"}
+
+// OCREngine recognizes the text of scanned or image-only PDFs
+type OCREngine interface {
+	// Name identifies the engine in the cache
+	Name() string
+	// Recognize returns the text of all pages of a PDF
+	Recognize(path string) (string, error)
+}
+
+func isOCREngine(name string) bool {
+	for _, engine := range ocrEngines {
+		if engine == name {
+			return true
+		}
+	}
+	return false
+}
+
+// newOCREngine returns the named engine for the given language, or nil if OCR
+// is disabled or, in auto mode, no engine is installed
+func newOCREngine(name, language string) (OCREngine, error) {
+	switch name {
+	case "off":
+		return nil, nil
+	case "auto":
+		engine, err := newTesseractOCR(language)
+		if err != nil {
+			log.Debug("OCR unavailable", "error", err)
+			return nil, nil
+		}
+		return engine, nil
+	case "tesseract":
+		return newTesseractOCR(language)
+	default:
+		return nil, fmt.Errorf("unsupported OCR engine %q", name)
+	}
+}
+
+// tesseractOCR renders pages with pdftoppm and recognizes them with the tesseract CLI
+type tesseractOCR struct {
+	tesseract string
+	pdftoppm  string
+	language  string
+}
+
+// ocrResolution is the resolution in DPI pages are rendered at for OCR
+const ocrResolution = 300
+
+// newTesseractOCR locates the tesseract and pdftoppm binaries
+func newTesseractOCR(language string) (*tesseractOCR, error) {
+	tesseract, err := exec.LookPath("tesseract")
+	if err != nil {
+		return nil, fmt.Errorf("tesseract not found: %v", err)
+	}
+	pdftoppm, err := exec.LookPath("pdftoppm")
+	if err != nil {
+		return nil, fmt.Errorf("pdftoppm not found: %v", err)
+	}
+	return &tesseractOCR{tesseract: tesseract, pdftoppm: pdftoppm, language: language}, nil
+}
+
+func (t *tesseractOCR) Name() string {
+	return "tesseract:" + t.language
+}
+
+func (t *tesseractOCR) Recognize(path string) (string, error) {
+	dir, err := os.MkdirTemp("", "adp-ocr-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	// Render each page to page-<n>.png, numbered with equal width
+	if err := runTool(t.pdftoppm, "-r", fmt.Sprint(ocrResolution), "-png", path, filepath.Join(dir, "page")); err != nil {
+		return "", err
+	}
+	pages, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(pages)
+
+	var text strings.Builder
+	for _, page := range pages {
+		cmd := exec.Command(t.tesseract, page, "stdout", "-l", t.language)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("tesseract failed on %s: %v: %s", filepath.Base(page), err, strings.TrimSpace(stderr.String()))
+		}
+		text.Write(out)
+	}
+	return text.String(), nil
+}
+
+// runTool runs an external command, including its output in the error
+func runTool(name string, args ...string) error {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s failed: %v: %s", filepath.Base(name), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// textLength counts the characters of a text other than whitespace
+func textLength(text string) int {
+	n := 0
+	for _, r := range text {
+		if !unicode.IsSpace(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// needsOCR reports whether text extracted from a PDF is too short to be its content
+func needsOCR(text string, opts readOptions) bool {
+	return opts.OCR != nil && textLength(text) < opts.OCRMinText
+}
+
+// extractText extracts the text of a PDF, falling back to OCR if the PDF has no
+// or too little embedded text. It also returns the name of the OCR engine that
+// recognized the text, if any.
+func extractText(path string, opts readOptions) (string, string, error) {
+	text, err := extractTextFromPDF(path)
+	if err == nil && !needsOCR(text, opts) {
+		return text, "", nil
+	}
+	if opts.OCR == nil {
+		return text, "", err
+	}
+
+	log.Info("Recognizing text with OCR", "filename", filepath.Base(path), "engine", opts.OCR.Name())
+	recognized, ocrErr := opts.OCR.Recognize(path)
+	if ocrErr != nil {
+		if err != nil {
+			return "", "", err
+		}
+		log.Warn("OCR failed", "filename", filepath.Base(path), "error", ocrErr)
+		return text, "", nil
+	}
+	return recognized, opts.OCR.Name(), nil
+}
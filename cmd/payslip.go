@@ -0,0 +1,275 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+)
+
+// Money is an amount in euro cents
+type Money int64
+
+// String formats the amount with a decimal point, e.g. "1234.56"
+func (m Money) String() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, m/100, m%100)
+}
+
+// German formats the amount the way ADP documents do, e.g. "1.234,56"
+func (m Money) German() string {
+	sign := ""
+	if m < 0 {
+		sign = "-"
+		m = -m
+	}
+	euros := strconv.FormatInt(int64(m/100), 10)
+	var grouped strings.Builder
+	for i, digit := range euros {
+		if i > 0 && (len(euros)-i)%3 == 0 {
+			grouped.WriteByte('.')
+		}
+		grouped.WriteRune(digit)
+	}
+	return fmt.Sprintf("%s%s,%02d", sign, grouped.String(), m%100)
+}
+
+// findAmount returns the first amount formatted in the locale following a
+// label on the same line
+func findAmount(text string, label *regexp.Regexp, locale Locale) (Money, bool) {
+	loc := label.FindStringIndex(text)
+	if loc == nil {
+		return 0, false
+	}
+	rest := text[loc[1]:]
+	if end := strings.IndexByte(rest, '\n'); end >= 0 {
+		rest = rest[:end]
+	}
+	match := locale.Amount.FindString(rest)
+	if match == "" {
+		return 0, false
+	}
+	amount, err := locale.parseAmount(match)
+	if err != nil {
+		return 0, false
+	}
+	return amount, true
+}
+
+// Payslip holds the amounts parsed from a Verdienstabrechnung
+type Payslip struct {
+	Document
+
+	// Gross is the Gesamtbrutto
+	Gross Money
+	// TaxableGross is the Steuer-Brutto, the part of the gross pay subject to
+	// income tax, without tax-free and flat-taxed pay
+	TaxableGross Money
+	// Taxes
+	IncomeTax           Money
+	SolidaritySurcharge Money
+	ChurchTax           Money
+	// Employee social insurance contributions
+	HealthInsurance       Money
+	PensionInsurance      Money
+	UnemploymentInsurance Money
+	CareInsurance         Money
+	// Net is the Nettoverdienst
+	Net Money
+	// Payout is the amount transferred to the bank account
+	Payout Money
+
+	// WageTypes maps Lohnart code and name to amount, e.g. "1000 Gehalt"
+	WageTypes map[string]Money
+	// TaxClass is the Steuerklasse
+	TaxClass string
+	// Rates maps contribution labels to percentages, e.g. "Zusatzbeitrag" to "1,30"
+	Rates map[string]string
+}
+
+// Taxes returns the total of all taxes withheld
+func (p Payslip) Taxes() Money {
+	return p.IncomeTax + p.SolidaritySurcharge + p.ChurchTax
+}
+
+// SocialInsurance returns the total of all employee social insurance contributions
+func (p Payslip) SocialInsurance() Money {
+	return p.HealthInsurance + p.PensionInsurance + p.UnemploymentInsurance + p.CareInsurance
+}
+
+// payslipField describes how to find one amount on a payslip
+type payslipField struct {
+	// name looks up the label in the locale's PayslipLabels
+	name  string
+	field func(p *Payslip) *Money
+	// extractor selects the text the label is searched in
+	extractor string
+}
+
+// payslipFields lists the amounts parsed from payslips
+var payslipFields = []payslipField{
+	{"gross", func(p *Payslip) *Money { return &p.Gross }, extractorLayout},
+	{"taxable_gross", func(p *Payslip) *Money { return &p.TaxableGross }, extractorLayout},
+	{"income_tax", func(p *Payslip) *Money { return &p.IncomeTax }, extractorPlain},
+	{"solidarity_surcharge", func(p *Payslip) *Money { return &p.SolidaritySurcharge }, extractorPlain},
+	{"church_tax", func(p *Payslip) *Money { return &p.ChurchTax }, extractorPlain},
+	{"health_insurance", func(p *Payslip) *Money { return &p.HealthInsurance }, extractorPlain},
+	{"pension_insurance", func(p *Payslip) *Money { return &p.PensionInsurance }, extractorPlain},
+	{"unemployment_insurance", func(p *Payslip) *Money { return &p.UnemploymentInsurance }, extractorPlain},
+	{"care_insurance", func(p *Payslip) *Money { return &p.CareInsurance }, extractorPlain},
+	{"net", func(p *Payslip) *Money { return &p.Net }, extractorLayout},
+	{"payout", func(p *Payslip) *Money { return &p.Payout }, extractorLayout},
+}
+
+var (
+	// Regex to extract the Steuerklasse
+	taxClassRegex = regexp.MustCompile(`(?:Steuerklasse|StKl|[Tt]ax class)\.?:?\s*([1-6]|I{1,3}|IV|VI?|V)\b`)
+
+	// Regex to extract contribution rates, e.g. "Zusatzbeitrag 1,30 %"
+	rateRegex = regexp.MustCompile(`(Zusatzbeitrag|KV|RV|AV|PV|Krankenversicherung|Rentenversicherung|Arbeitslosenversicherung|Pflegeversicherung)[^\n%]*?(\d{1,2},\d{1,3})\s*%`)
+)
+
+// parsePayslip extracts the amounts from a classified payslip using the labels
+// and number format of its locale. Deductions are stored as positive amounts.
+func parsePayslip(doc Document) (Payslip, error) {
+	return parsePayslipLayout(doc, lazyLayout(doc))
+}
+
+// parsePayslipLayout is parsePayslip with the document's layout, matching
+// labels and Lohnarten on the lines of the layout
+func parsePayslipLayout(doc Document, layout func() *Layout) (Payslip, error) {
+	if doc.Type != DocumentTypePayslip {
+		return Payslip{}, fmt.Errorf("not a payslip: %s", doc.Type)
+	}
+
+	payslip := Payslip{Document: doc}
+	locale := documentLocale(doc)
+	lines := documentLines(doc, layout)
+	found := make(map[string]bool)
+	for _, f := range payslipFields {
+		if amount, ok := findRuleAmount(lines, layout, f.extractor, locale.PayslipLabels[f.name], locale); ok {
+			if f.name != "gross" && f.name != "taxable_gross" && f.name != "net" && f.name != "payout" && amount < 0 {
+				amount = -amount
+			}
+			*f.field(&payslip) = amount
+			found[f.name] = true
+		}
+	}
+
+	payslip.WageTypes = make(map[string]Money)
+	for _, matches := range locale.WageType.FindAllStringSubmatch(lines, -1) {
+		amount, err := locale.parseAmount(matches[3])
+		if err != nil {
+			continue
+		}
+		payslip.WageTypes[matches[1]+" "+strings.TrimSpace(matches[2])] += amount
+	}
+
+	if matches := taxClassRegex.FindStringSubmatch(lines); len(matches) > 1 {
+		payslip.TaxClass = matches[1]
+	}
+
+	payslip.Rates = make(map[string]string)
+	for _, matches := range rateRegex.FindAllStringSubmatch(lines, -1) {
+		if _, ok := payslip.Rates[matches[1]]; !ok {
+			payslip.Rates[matches[1]] = matches[2]
+		}
+	}
+
+	if payslip.Gross == 0 {
+		return payslip, fmt.Errorf("couldn't find gross pay in %s", doc.Path)
+	}
+	if payslip.Payout == 0 {
+		payslip.Payout = payslip.Net
+	}
+	// Payslips without tax-free or flat-taxed pay may not show a Steuer-Brutto
+	if !found["taxable_gross"] {
+		payslip.TaxableGross = payslip.Gross
+	}
+
+	return payslip, nil
+}
+
+// Fields returns the parsed amounts keyed by field name
+func (p Payslip) Fields() map[string]Money {
+	fields := make(map[string]Money, len(payslipFields))
+	for _, f := range payslipFields {
+		fields[f.name] = *f.field(&p)
+	}
+	return fields
+}
+
+// plus returns the field-wise sum of two payslips
+func (p Payslip) plus(q Payslip) Payslip {
+	sum := p
+	for _, f := range payslipFields {
+		*f.field(&sum) = *f.field(&p) + *f.field(&q)
+	}
+	return sum
+}
+
+// minus returns the field-wise difference between two payslips
+func (p Payslip) minus(q Payslip) Payslip {
+	diff := p
+	for _, f := range payslipFields {
+		*f.field(&diff) = *f.field(&p) - *f.field(&q)
+	}
+	return diff
+}
+
+// parsePayslips parses all payslips among the documents, skipping those without amounts
+func parsePayslips(docs []Document) []Payslip {
+	var payslips []Payslip
+	for _, doc := range docs {
+		if doc.Type != DocumentTypePayslip {
+			continue
+		}
+		payslip, err := parsePayslip(doc)
+		if err != nil {
+			log.Warn("Skipping payslip", "filename", filepath.Base(doc.Path), "error", err)
+			continue
+		}
+		payslips = append(payslips, payslip)
+	}
+	return payslips
+}
+
+// effectivePayslips returns the latest version of each month's payslip, ordered by month.
+// The payslips' corrections must have been linked by linkCorrections.
+func effectivePayslips(payslips []Payslip) []Payslip {
+	latest := make(map[Period]int)
+	var effective []Payslip
+	for _, payslip := range payslips {
+		period := payslip.EffectivePeriod()
+		i, ok := latest[period]
+		if !ok {
+			latest[period] = len(effective)
+			effective = append(effective, payslip)
+			continue
+		}
+		if supersedes(payslip.Document, effective[i].Document) {
+			effective[i] = payslip
+		}
+	}
+
+	sort.SliceStable(effective, func(i, j int) bool {
+		return effective[i].EffectivePeriod().Before(effective[j].EffectivePeriod())
+	})
+	return effective
+}
+
+// supersedes reports whether a payslip replaces another version of the same month
+func supersedes(doc, other Document) bool {
+	if doc.IsCorrection() != other.IsCorrection() {
+		return doc.IsCorrection()
+	}
+	return doc.CorrectionNumber > other.CorrectionNumber
+}
@@ -0,0 +1,198 @@
+package cmd
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/ledongthuc/pdf"
+)
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		locale  Locale
+		in      string
+		want    Money
+		wantErr bool
+	}{
+		{germanLocale, "0,00", 0, false},
+		{germanLocale, "0,01", 1, false},
+		{germanLocale, "0,99", 99, false},
+		{germanLocale, "12,34", 1234, false},
+		{germanLocale, "1.234,56", 123456, false},
+		{germanLocale, "1.234.567,89", 123456789, false},
+		{germanLocale, " 5.000,00 ", 500000, false},
+		{germanLocale, "-12,00", -1200, false},
+		{germanLocale, "12,00-", -1200, false},
+		{germanLocale, "1.234,56-", -123456, false},
+		{germanLocale, "-0,01", -1, false},
+		{germanLocale, "", 0, true},
+		{germanLocale, "abc", 0, true},
+		{englishLocale, "4,500.00", 450000, false},
+		{englishLocale, "1,234,567.89", 123456789, false},
+		{englishLocale, "812.41-", -81241, false},
+		{englishLocale, "-0.01", -1, false},
+	}
+	for _, tt := range tests {
+		got, err := tt.locale.parseAmount(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s parseAmount(%q) error = %v, wantErr %v", tt.locale.Name, tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s parseAmount(%q) = %d, want %d", tt.locale.Name, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestMoneyFormat(t *testing.T) {
+	tests := []struct {
+		m      Money
+		str    string
+		german string
+	}{
+		{0, "0.00", "0,00"},
+		{1, "0.01", "0,01"},
+		{-1, "-0.01", "-0,01"},
+		{100, "1.00", "1,00"},
+		{123456, "1234.56", "1.234,56"},
+		{-123456, "-1234.56", "-1.234,56"},
+		{100000000, "1000000.00", "1.000.000,00"},
+	}
+	for _, tt := range tests {
+		if got := tt.m.String(); got != tt.str {
+			t.Errorf("Money(%d).String() = %q, want %q", tt.m, got, tt.str)
+		}
+		if got := tt.m.German(); got != tt.german {
+			t.Errorf("Money(%d).German() = %q, want %q", tt.m, got, tt.german)
+		}
+	}
+}
+
+func TestMoneySumIsExact(t *testing.T) {
+	// Amounts are cents, so sums don't accumulate rounding errors
+	var sum Money
+	for i := 0; i < 1000; i++ {
+		amount, err := germanLocale.parseAmount("0,10")
+		if err != nil {
+			t.Fatal(err)
+		}
+		sum += amount
+	}
+	if sum != 10000 {
+		t.Errorf("sum = %s, want 100.00", sum)
+	}
+}
+
+func TestFindAmount(t *testing.T) {
+	text := "Gesamtbrutto 5.000,00\nLohnsteuer 812,41-\nKirchensteuer\n73,11\nNettoverdienst 3.050,12"
+	tests := []struct {
+		label  string
+		want   Money
+		wantOk bool
+	}{
+		{`Gesamtbrutto`, 500000, true},
+		{`Lohnsteuer`, -81241, true},
+		{`Nettoverdienst`, 305012, true},
+		// Amounts on the next line don't belong to the label
+		{`Kirchensteuer`, 0, false},
+		{`Solidaritätszuschlag`, 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := findAmount(text, regexp.MustCompile(tt.label), germanLocale)
+		if ok != tt.wantOk || got != tt.want {
+			t.Errorf("findAmount(%s) = %d, %v, want %d, %v", tt.label, got, ok, tt.want, tt.wantOk)
+		}
+	}
+}
+
+func TestFindAmountInLocale(t *testing.T) {
+	tests := []struct {
+		locale Locale
+		text   string
+		want   Money
+	}{
+		{germanLocale, "Gesamtbrutto 4.500,00", 450000},
+		{germanLocale, "Gesamtbrutto 4500,00", 450000},
+		{englishLocale, "Total gross 4,500.00", 450000},
+		{englishLocale, "Total gross 4500.00", 450000},
+	}
+	for _, tt := range tests {
+		got, ok := findAmount(tt.text, regexp.MustCompile(`(?i)Gesamtbrutto|Total gross`), tt.locale)
+		if !ok || got != tt.want {
+			t.Errorf("%s findAmount(%q) = %d, %v, want %d", tt.locale.Name, tt.text, got, ok, tt.want)
+		}
+	}
+}
+
+func TestParsePayslipByLocale(t *testing.T) {
+	text := "Pay slip\nPayroll period: March 2024\nTotal gross 4,500.00\nIncome tax 812.41-\n1000 Salary 4,500.00\nNet pay 2,950.12\n"
+	doc, err := classifyDocument("payslip.pdf", text, nil)
+	if err != nil {
+		t.Fatalf("classifyDocument: %v", err)
+	}
+	if doc.Locale != englishLocale.Name {
+		t.Fatalf("locale = %q, want %q", doc.Locale, englishLocale.Name)
+	}
+
+	payslip, err := parsePayslip(doc)
+	if err != nil {
+		t.Fatalf("parsePayslip: %v", err)
+	}
+	if payslip.Gross != 450000 || payslip.IncomeTax != 81241 || payslip.Net != 295012 {
+		t.Errorf("parsePayslip = gross %s, income tax %s, net %s, want 4500.00, 812.41, 2950.12", payslip.Gross, payslip.IncomeTax, payslip.Net)
+	}
+	if amount := payslip.WageTypes["1000 Salary"]; amount != 450000 {
+		t.Errorf("wage type 1000 Salary = %s, want 4500.00", amount)
+	}
+}
+
+// glyphs returns the glyphs of a run of text starting at x on baseline y, the
+// way the PDF library reports page content
+func glyphs(x, y float64, s string) []pdf.Text {
+	var text []pdf.Text
+	for _, r := range s {
+		text = append(text, pdf.Text{FontSize: 10, X: x, Y: y, W: 5, S: string(r)})
+		x += 5
+	}
+	return text
+}
+
+func TestParsePayslipFromLayout(t *testing.T) {
+	// The plain text runs the page into one line, as for PDFs positioning
+	// their lines with Td
+	text := "Verdienstabrechnung Abrechnungsmonat Januar 2024 1000 Gehalt 5.000,00 Gesamtbrutto 5.000,00 Lohnsteuer 812,41- Kirchensteuer Nettoverdienst 3.050,12"
+	var page []pdf.Text
+	for _, row := range []struct {
+		y     float64
+		cells []string
+	}{
+		{700, []string{"Verdienstabrechnung"}},
+		{680, []string{"Abrechnungsmonat Januar 2024"}},
+		{660, []string{"1000 Gehalt", "5.000,00"}},
+		{640, []string{"Gesamtbrutto", "5.000,00"}},
+		{620, []string{"Lohnsteuer", "812,41-"}},
+		{600, []string{"Kirchensteuer"}},
+		{580, []string{"Nettoverdienst", "3.050,12"}},
+	} {
+		for i, cell := range row.cells {
+			page = append(page, glyphs(50+float64(i)*300, row.y, cell)...)
+		}
+	}
+	layout := &Layout{Lines: layoutLines(1, page)}
+
+	doc := Document{Path: "payslip.pdf", Type: DocumentTypePayslip, Locale: germanLocale.Name, Text: text}
+	payslip, err := parsePayslipLayout(doc, func() *Layout { return layout })
+	if err != nil {
+		t.Fatalf("parsePayslipLayout: %v", err)
+	}
+	if payslip.Gross != 500000 || payslip.IncomeTax != 81241 || payslip.Net != 305012 {
+		t.Errorf("parsePayslipLayout = gross %s, income tax %s, net %s, want 5000.00, 812.41, 3050.12", payslip.Gross, payslip.IncomeTax, payslip.Net)
+	}
+	// The amount on the merged line after Kirchensteuer is the Nettoverdienst
+	if payslip.ChurchTax != 0 {
+		t.Errorf("church tax = %s, want 0.00", payslip.ChurchTax)
+	}
+	if amount := payslip.WageTypes["1000 Gehalt"]; amount != 500000 {
+		t.Errorf("wage type 1000 Gehalt = %s, want 5000.00", amount)
+	}
+}
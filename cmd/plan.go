@@ -0,0 +1,528 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// planFormats lists the supported output formats of a dry run
+var planFormats = []string{"text", "json"}
+
+// Plan lists the changes a process run makes
+type Plan struct {
+	Paths     []string      `json:"paths"`
+	CreatedAt string        `json:"created_at"`
+	Files     []PlannedFile `json:"files"`
+}
+
+// PlannedFile is the classification and proposed target of one PDF.
+// Source, Target and Supersedes are relative to Root, one of the plan's paths.
+type PlannedFile struct {
+	Root             string            `json:"root"`
+	Source           string            `json:"source"`
+	Hash             string            `json:"hash"`
+	Type             DocumentType      `json:"type,omitempty"`
+	Period           string            `json:"period,omitempty"`
+	CorrectedPeriod  string            `json:"corrected_period,omitempty"`
+	CorrectionNumber int               `json:"correction_number,omitempty"`
+	Supersedes       string            `json:"supersedes,omitempty"`
+	OriginalFilename string            `json:"original_filename,omitempty"`
+	Fields           map[string]string `json:"fields,omitempty"`
+	// Target is the new filename, equal to Source if already named correctly
+	// and empty if the file is left alone
+	Target    string   `json:"target,omitempty"`
+	Conflicts []string `json:"conflicts,omitempty"`
+	Warnings  []string `json:"warnings,omitempty"`
+}
+
+func isPlanFormat(format string) bool {
+	for _, f := range planFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// path returns the location of a file relative to the planned file's root
+func (f PlannedFile) path(name string) string {
+	return filepath.Join(f.Root, name)
+}
+
+// Document rebuilds the classification of a planned file
+func (f PlannedFile) Document() (Document, error) {
+	doc, err := newDocument(f.path(f.Source), f.Hash, f.Type, f.Period, f.CorrectedPeriod)
+	if err != nil {
+		return doc, fmt.Errorf("%s: %v", f.Source, err)
+	}
+	doc.CorrectionNumber = f.CorrectionNumber
+	if f.Supersedes != "" {
+		doc.Supersedes = f.path(f.Supersedes)
+	}
+	return doc, nil
+}
+
+// planProcess classifies all PDFs found in the directories and proposes their new
+// filenames without changing anything on disk. Files are extracted and classified by up to
+// opts.Jobs workers; targets are assigned afterwards in filename order, so the
+// plan doesn't depend on which worker finished first.
+func planProcess(pdfPaths []string, opts processOptions) (*Plan, error) {
+	// Find all PDF files in the directories
+	pdfFiles, err := findPDFs(pdfPaths, opts.Walk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list PDF files: %v", err)
+	}
+
+	log.Info("Found PDF files", "count", len(pdfFiles), "jobs", opts.Jobs)
+
+	plan := &Plan{Paths: pdfPaths, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+
+	// Extract and classify the files in parallel, keeping the results in file order
+	plan.Files = make([]PlannedFile, len(pdfFiles))
+	classified := make([]*Document, len(pdfFiles))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < max(opts.Jobs, 1); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				log.Info("Processing PDF",
+					"number", fmt.Sprintf("%d/%d", i+1, len(pdfFiles)),
+					"filename", filepath.Base(pdfFiles[i].Path))
+				plan.Files[i], classified[i] = planFile(pdfFiles[i], opts)
+			}
+		}()
+	}
+	for i := range pdfFiles {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	// Remember where each document is planned
+	var docs []Document
+	planned := make(map[string]int)
+	for i, doc := range classified {
+		if doc != nil {
+			planned[doc.Path] = i
+			docs = append(docs, *doc)
+		}
+	}
+
+	// Number successive Rückrechnungen of the same month
+	linkCorrections(docs)
+
+	// Propose a unique target for each classified document
+	targets := make(map[string]bool)
+	sources := make(map[string]string)
+	for _, doc := range docs {
+		file := &plan.Files[planned[doc.Path]]
+		file.Type = doc.Type
+		file.Period = doc.Period.Key()
+		if doc.Correction != nil {
+			file.CorrectedPeriod = doc.Correction.Key()
+		}
+		file.CorrectionNumber = doc.CorrectionNumber
+
+		// Files are renamed within their directory
+		target := filepath.Join(filepath.Dir(doc.Path), doc.Filename())
+		if target != doc.Path {
+			unique := ensureUniqueFilename(target, doc.Path, targets)
+			// A file keeping its suffixed name was resolved by an earlier run
+			if unique != target && unique != doc.Path {
+				if source, ok := sources[target]; ok {
+					file.Conflicts = append(file.Conflicts, fmt.Sprintf("%s is also the target of %s", filepath.Base(target), source))
+				} else {
+					file.Conflicts = append(file.Conflicts, fmt.Sprintf("%s already exists", filepath.Base(target)))
+				}
+			}
+			target = unique
+		}
+		targets[target] = true
+		sources[target] = file.Source
+		file.Target = filepath.Join(filepath.Dir(file.Source), filepath.Base(target))
+	}
+
+	// Refer to superseded versions by their new names
+	for _, doc := range docs {
+		if doc.Supersedes != "" {
+			superseded := plan.Files[planned[doc.Supersedes]]
+			plan.Files[planned[doc.Path]].Supersedes = superseded.Target
+		}
+	}
+
+	return plan, nil
+}
+
+// planFile hashes and classifies a single PDF. The document is nil if the file
+// couldn't be classified, in which case the planned file carries a warning.
+func planFile(found pdfFile, opts processOptions) (PlannedFile, *Document) {
+	pdfFile := found.Path
+	filename := filepath.Base(pdfFile)
+	source, err := filepath.Rel(found.Root, pdfFile)
+	if err != nil {
+		source = filename
+	}
+	file := PlannedFile{Root: found.Root, Source: source, OriginalFilename: filename}
+	warn := func(msg string, keyvals ...interface{}) {
+		log.Warn(msg, append([]interface{}{"filename", filename}, keyvals...)...)
+		file.Warnings = append(file.Warnings, msg)
+	}
+
+	// Hash the content to identify the document in the index, sidecars, journal and cache
+	if file.Hash, err = fileSHA256(pdfFile); err != nil {
+		warn("Failed to hash PDF", "error", err)
+	}
+
+	// Reuse the classification of an earlier run if the content and rules are unchanged
+	if sidecar, _, ok := readSidecar(pdfFile); ok && file.Hash != "" && sidecar.Hash == file.Hash && sidecar.RulesVersion == rulesVersion {
+		if doc, err := sidecar.Document(pdfFile); err == nil {
+			log.Debug("Using sidecar metadata", "filename", filename)
+			if sidecar.OriginalFilename != "" {
+				file.OriginalFilename = sidecar.OriginalFilename
+			}
+			file.Fields = sidecar.Fields
+			doc.CorrectionNumber = sidecar.CorrectionNumber
+			return file, &doc
+		}
+	}
+
+	doc, fields, err := readDocument(pdfFile, file.Hash, opts.readOptions)
+	if doc.Type == DocumentTypeUnknown && err != nil {
+		warn("Failed to read PDF", "error", err)
+		return file, nil
+	}
+
+	if doc.Type == DocumentTypeUnknown {
+		log.Info("Not a recognized certificate type", "filename", filename)
+		file.Warnings = append(file.Warnings, "Not a recognized certificate type")
+		return file, nil
+	}
+	if err != nil {
+		warn("Found document but couldn't extract month/year", "type", doc.Type)
+		file.Type = doc.Type
+		return file, nil
+	}
+
+	file.Fields = fields
+	doc.CorrectionNumber = recordedCorrectionNumber(doc, opts.Index)
+	return file, &doc
+}
+
+// validate checks that a reviewed plan can be executed exactly: every file is
+// unchanged since planning and no rename overwrites an existing file
+func (p *Plan) validate() error {
+	// Names freed by renames of the plan itself
+	moving := make(map[string]bool)
+	for _, file := range p.Files {
+		if file.Target != "" && file.Target != file.Source {
+			moving[file.path(file.Source)] = true
+		}
+	}
+
+	var problems []string
+	targets := make(map[string]string)
+	for _, file := range p.Files {
+		if file.Target == "" {
+			continue
+		}
+		if !isPlanRoot(p.Paths, file.Root) {
+			problems = append(problems, fmt.Sprintf("%s: %s is not one of the plan's paths", file.Source, file.Root))
+			continue
+		}
+		if !filepath.IsLocal(file.Source) || !filepath.IsLocal(file.Target) || filepath.Dir(file.Source) != filepath.Dir(file.Target) {
+			problems = append(problems, fmt.Sprintf("%s: target must stay in the same directory below %s", file.Source, file.Root))
+			continue
+		}
+		if _, err := file.Document(); err != nil {
+			problems = append(problems, err.Error())
+		}
+
+		hash, err := fileSHA256(file.path(file.Source))
+		switch {
+		case os.IsNotExist(err):
+			problems = append(problems, fmt.Sprintf("%s no longer exists", file.Source))
+		case err != nil:
+			problems = append(problems, fmt.Sprintf("%s: %v", file.Source, err))
+		case hash != file.Hash:
+			problems = append(problems, fmt.Sprintf("%s has changed since the plan was made", file.Source))
+		}
+
+		target := file.path(file.Target)
+		if other, ok := targets[target]; ok {
+			problems = append(problems, fmt.Sprintf("%s is the target of both %s and %s", file.Target, other, file.Source))
+		}
+		targets[target] = file.Source
+
+		if file.Target != file.Source && !moving[target] {
+			if _, err := os.Stat(target); err == nil {
+				problems = append(problems, fmt.Sprintf("%s already exists", file.Target))
+			}
+		}
+	}
+	if _, err := p.renameOrder(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("refusing to apply plan:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// renameOrder returns the indexes of the plan's files in the order they can be
+// renamed without overwriting each other: a file whose target is the source of
+// another renamed file comes after that file. Renames forming a cycle can't be
+// ordered and are rejected.
+func (p *Plan) renameOrder() ([]int, error) {
+	sources := make(map[string]int)
+	for i, file := range p.Files {
+		if file.Target != "" && file.Target != file.Source {
+			sources[file.path(file.Source)] = i
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make([]int, len(p.Files))
+	order := make([]int, 0, len(p.Files))
+	// chain holds the files being visited, each one's target the next one's source
+	var chain []int
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case done:
+			return nil
+		case visiting:
+			var cycle []string
+			for _, j := range chain[slices.Index(chain, i):] {
+				cycle = append(cycle, p.Files[j].Source)
+			}
+			return fmt.Errorf("renames of %s form a cycle", strings.Join(cycle, ", "))
+		}
+		state[i] = visiting
+		file := p.Files[i]
+		chain = append(chain, i)
+		if file.Target != "" && file.Target != file.Source {
+			if j, ok := sources[file.path(file.Target)]; ok {
+				if err := visit(j); err != nil {
+					return err
+				}
+			}
+		}
+		chain = chain[:len(chain)-1]
+		state[i] = done
+		order = append(order, i)
+		return nil
+	}
+	for i := range p.Files {
+		if err := visit(i); err != nil {
+			return nil, err
+		}
+	}
+	return order, nil
+}
+
+// applyPlan renames, indexes and annotates the planned documents
+func applyPlan(plan *Plan, opts processOptions) error {
+	order, err := plan.renameOrder()
+	if err != nil {
+		return err
+	}
+
+	for _, i := range order {
+		file := plan.Files[i]
+		if file.Target == "" {
+			continue
+		}
+
+		doc, err := file.Document()
+		if err != nil {
+			log.Warn("Skipping invalid plan entry", "error", err)
+			continue
+		}
+		filename := file.Source
+		newPath := file.path(file.Target)
+		renamed := false
+
+		if opts.Index != nil && doc.Hash != "" {
+			if err := opts.Index.RecordDocument(doc.Hash, doc, file.Fields); err != nil {
+				log.Warn("Failed to index document", "filename", filename, "error", err)
+			}
+		}
+
+		if newPath == doc.Path {
+			log.Info("Already named correctly", "filename", filename)
+		} else {
+			logPlannedRename(doc, file)
+
+			// Never overwrite a file, even if it appeared after planning
+			if _, err := os.Stat(newPath); err == nil {
+				log.Error("Failed to rename file", "filename", filename, "error", fmt.Sprintf("%s already exists", file.Target))
+				continue
+			}
+			if err := os.Rename(doc.Path, newPath); err != nil {
+				log.Error("Failed to rename file", "filename", filename, "error", err)
+				continue
+			}
+			log.Info("Renamed file successfully", "old", filename, "new", file.Target)
+			renamed = true
+
+			if opts.Index != nil && doc.Hash != "" {
+				if err := opts.Index.UpdatePath(doc.Hash, newPath); err != nil {
+					log.Warn("Failed to update index", "filename", file.Target, "error", err)
+				}
+			}
+		}
+
+		var decrypted, backup string
+		if opts.Decrypted != "" {
+			doc.Hash, decrypted, backup = storeProcessDecrypted(doc, newPath, opts)
+		}
+
+		embedded := false
+		if opts.EmbedMetadata {
+			hash := embedProcessMetadata(doc, newPath, file.Fields, opts)
+			embedded = hash != doc.Hash
+			doc.Hash = hash
+		}
+
+		var sidecar string
+		if opts.Sidecar != "" {
+			sidecar = writeProcessSidecar(doc, newPath, file, opts)
+		}
+
+		if opts.Written != nil {
+			*opts.Written = append(*opts.Written, newPath)
+			for _, path := range []string{decrypted, sidecar} {
+				if path != "" {
+					*opts.Written = append(*opts.Written, path)
+				}
+			}
+		}
+
+		// Replaced originals are journaled even without a rename, so that undo restores them
+		if (renamed || backup != "") && opts.Journal != nil {
+			opts.Journal.Renames = append(opts.Journal.Renames, JournalEntry{
+				From:             doc.Path,
+				To:               newPath,
+				OriginalHash:     file.Hash,
+				Hash:             doc.Hash,
+				Sidecar:          sidecar,
+				Decrypted:        decrypted,
+				Backup:           backup,
+				EmbeddedMetadata: embedded,
+			})
+		}
+	}
+
+	return nil
+}
+
+// reportPlan describes the plan without applying it
+func reportPlan(w io.Writer, plan *Plan, opts processOptions) error {
+	if opts.Output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	}
+
+	for _, file := range plan.Files {
+		if file.Target == "" {
+			continue
+		}
+
+		doc, err := file.Document()
+		if err != nil {
+			log.Warn("Skipping invalid plan entry", "error", err)
+			continue
+		}
+
+		if file.Target == file.Source {
+			log.Info("Already named correctly", "filename", file.Source)
+		} else {
+			logPlannedRename(doc, file)
+			for _, conflict := range file.Conflicts {
+				log.Warn("Target is taken", "filename", file.Source, "conflict", conflict)
+			}
+			log.Info("Would rename", "filename", file.Source, "new_filename", file.Target)
+		}
+		if opts.EmbedMetadata {
+			log.Info("Would embed metadata", "filename", file.Target)
+		}
+		if opts.Sidecar != "" {
+			log.Info("Would write sidecar", "filename", filepath.Base(sidecarPath(file.Target, opts.Sidecar)))
+		}
+	}
+	return nil
+}
+
+// logPlannedRename logs the classification of a document about to be renamed
+func logPlannedRename(doc Document, file PlannedFile) {
+	log.Info("Found document",
+		"filename", file.Source,
+		"type", doc.Type,
+		"period", doc.Period,
+		"new_filename", file.Target)
+	if doc.IsCorrection() {
+		log.Info("Found Rückrechnung",
+			"filename", file.Source,
+			"corrected_month", doc.Correction,
+			"correction_number", doc.CorrectionNumber,
+			"supersedes", file.Supersedes)
+	}
+}
+
+// relocate moves the plan to other directories, e.g. a checkout of the same
+// archive on another machine. The paths replace the plan's paths in order.
+func (p *Plan) relocate(paths []string) error {
+	if len(paths) != len(p.Paths) {
+		return fmt.Errorf("plan has %d paths, got %d", len(p.Paths), len(paths))
+	}
+	roots := make(map[string]string)
+	for i, path := range p.Paths {
+		roots[filepath.Clean(path)] = paths[i]
+	}
+	for i := range p.Files {
+		if root, ok := roots[filepath.Clean(p.Files[i].Root)]; ok {
+			p.Files[i].Root = root
+		}
+	}
+	p.Paths = paths
+	return nil
+}
+
+// isPlanRoot reports whether root is one of the plan's paths
+func isPlanRoot(paths []string, root string) bool {
+	for _, path := range paths {
+		if filepath.Clean(path) == filepath.Clean(root) {
+			return true
+		}
+	}
+	return false
+}
+
+// readPlan reads a plan written by a dry run
+func readPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var plan Plan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to decode plan %s: %v", path, err)
+	}
+	return &plan, nil
+}
@@ -0,0 +1,371 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPlan writes a file named after each source holding its name and plans
+// renaming it to the corresponding target
+func testPlan(t *testing.T, dir string, renames ...[2]string) *Plan {
+	t.Helper()
+	plan := &Plan{Paths: []string{dir}}
+	for i, rename := range renames {
+		path := filepath.Join(dir, rename[0])
+		if err := os.WriteFile(path, []byte(rename[0]), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := fileSHA256(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan.Files = append(plan.Files, PlannedFile{
+			Root:   dir,
+			Source: rename[0],
+			Hash:   hash,
+			Type:   DocumentTypePayslip,
+			Period: Period{Year: 2024, Month: time.Month(i + 1)}.Key(),
+			Target: rename[1],
+		})
+	}
+	return plan
+}
+
+func TestRenameOrder(t *testing.T) {
+	plan := testPlan(t, t.TempDir(),
+		[2]string{"a.pdf", "b.pdf"},
+		[2]string{"b.pdf", "c.pdf"},
+		[2]string{"c.pdf", "d.pdf"},
+		[2]string{"e.pdf", "e.pdf"},
+	)
+
+	order, err := plan.renameOrder()
+	if err != nil {
+		t.Fatalf("renameOrder: %v", err)
+	}
+	position := make(map[string]int)
+	for n, i := range order {
+		position[plan.Files[i].Source] = n
+	}
+	if len(order) != len(plan.Files) {
+		t.Fatalf("order has %d files, want %d", len(order), len(plan.Files))
+	}
+	if !(position["c.pdf"] < position["b.pdf"] && position["b.pdf"] < position["a.pdf"]) {
+		t.Errorf("order = %v, want c.pdf before b.pdf before a.pdf", order)
+	}
+}
+
+func TestValidateRejectsCycle(t *testing.T) {
+	plan := testPlan(t, t.TempDir(),
+		[2]string{"a.pdf", "b.pdf"},
+		[2]string{"b.pdf", "a.pdf"},
+		[2]string{"c.pdf", "d.pdf"},
+	)
+
+	err := plan.validate()
+	if err == nil {
+		t.Fatal("validate accepted renames forming a cycle")
+	}
+	if !strings.Contains(err.Error(), "a.pdf, b.pdf form a cycle") {
+		t.Errorf("validate error = %v, want the cycle of a.pdf and b.pdf", err)
+	}
+}
+
+func TestValidateRejectsExistingTarget(t *testing.T) {
+	dir := t.TempDir()
+	plan := testPlan(t, dir, [2]string{"a.pdf", "b.pdf"})
+	if err := os.WriteFile(filepath.Join(dir, "b.pdf"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := plan.validate(); err == nil || !strings.Contains(err.Error(), "b.pdf already exists") {
+		t.Errorf("validate error = %v, want b.pdf already exists", err)
+	}
+}
+
+func TestApplyPlanRenamesChain(t *testing.T) {
+	dir := t.TempDir()
+	// a.pdf moves onto b.pdf, which moves away later in the plan
+	plan := testPlan(t, dir,
+		[2]string{"a.pdf", "b.pdf"},
+		[2]string{"b.pdf", "c.pdf"},
+	)
+	if err := plan.validate(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+
+	journal := newJournal(plan.Paths)
+	if err := applyPlan(plan, processOptions{Journal: journal}); err != nil {
+		t.Fatalf("applyPlan: %v", err)
+	}
+
+	for name, want := range map[string]string{"b.pdf": "a.pdf", "c.pdf": "b.pdf"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != want {
+			t.Errorf("%s holds %s, want %s", name, data, want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a.pdf")); !os.IsNotExist(err) {
+		t.Errorf("a.pdf still exists")
+	}
+	if len(journal.Renames) != 2 {
+		t.Fatalf("journal has %d renames, want 2", len(journal.Renames))
+	}
+
+	// Undoing the run restores the original names
+	if err := undoRun(journal, filepath.Join(dir, "journal"), nil); err != nil {
+		t.Fatalf("undoRun: %v", err)
+	}
+	for _, name := range []string{"a.pdf", "b.pdf"} {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != name {
+			t.Errorf("%s holds %s after undo", name, data)
+		}
+	}
+}
+
+func TestPlanFileIgnoresStaleSidecar(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scan.pdf")
+	if err := os.WriteFile(path, testPDF(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := fileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sidecar := Sidecar{Type: DocumentTypePayslip, Period: "2024-03", Hash: hash, RulesVersion: rulesVersion}
+	if err := writeSidecar(sidecarPath(path, "json"), "json", sidecar); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, doc := planFile(pdfFile{Path: path, Root: dir}, processOptions{}); doc == nil || doc.Type != DocumentTypePayslip {
+		t.Errorf("planFile didn't use the current sidecar, got %v", doc)
+	}
+
+	sidecar.RulesVersion = rulesVersion - 1
+	if err := writeSidecar(sidecarPath(path, "json"), "json", sidecar); err != nil {
+		t.Fatal(err)
+	}
+	// The PDF has no text, so it is only classified through a sidecar
+	if _, doc := planFile(pdfFile{Path: path, Root: dir}, processOptions{}); doc != nil {
+		t.Errorf("planFile used a sidecar of other rules, got %v", doc)
+	}
+}
+
+func TestReadDocumentReadOnlyCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scan.pdf")
+	if err := os.WriteFile(path, testPDF(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := fileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := filepath.Join(dir, "cache")
+
+	// A dry run doesn't create the cache
+	if _, err := OpenCacheReadOnly(cacheDir); !os.IsNotExist(err) {
+		t.Fatalf("OpenCacheReadOnly on missing cache: err = %v, want not exist", err)
+	}
+
+	cache, err := OpenCache(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOnly, err := OpenCacheReadOnly(cacheDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := readDocument(path, hash, readOptions{Cache: readOnly}); err != nil {
+		t.Fatalf("readDocument: %v", err)
+	}
+	if _, ok := cache.Lookup(hash); ok {
+		t.Error("reading with a read-only cache wrote to it")
+	}
+
+	if _, _, err := readDocument(path, hash, readOptions{Cache: cache}); err != nil {
+		t.Fatalf("readDocument: %v", err)
+	}
+	if _, ok := cache.Lookup(hash); !ok {
+		t.Error("readDocument didn't write to the cache")
+	}
+}
+
+func TestScanDocumentsUsesCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "scan.pdf")
+	if err := os.WriteFile(path, testPDF(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := fileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := OpenCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A classification cached by process, e.g. of text recognized with OCR
+	doc := Document{Path: path, Hash: hash, Type: DocumentTypePayslip, Period: Period{Year: 2024, Month: time.March}}
+	if err := cache.Store(doc, nil, "tesseract:deu"); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, unclassified, err := scanDocuments([]string{dir}, defaultWalkOptions, readOptions{Cache: cache})
+	if err != nil {
+		t.Fatalf("scanDocuments: %v", err)
+	}
+	if len(docs) != 1 || docs[0].Type != DocumentTypePayslip || len(unclassified) != 0 {
+		t.Errorf("scanDocuments = %v, unclassified %v, want the cached payslip", docs, unclassified)
+	}
+}
+
+func TestScanDocumentsWalksLikeProcess(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "2024", "scan.pdf")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, testPDF(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := fileSHA256(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := OpenCache(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := Document{Path: path, Hash: hash, Type: DocumentTypePayslip, Period: Period{Year: 2024, Month: time.March}}
+	if err := cache.Store(doc, nil, "tesseract:deu"); err != nil {
+		t.Fatal(err)
+	}
+
+	docs, _, err := scanDocuments([]string{dir}, defaultWalkOptions, readOptions{Cache: cache})
+	if err != nil {
+		t.Fatalf("scanDocuments: %v", err)
+	}
+	if len(docs) != 0 {
+		t.Errorf("scanDocuments without --recursive = %v, want no documents", docs)
+	}
+
+	walk := defaultWalkOptions
+	walk.Recursive = true
+	docs, _, err = scanDocuments([]string{dir}, walk, readOptions{Cache: cache})
+	if err != nil {
+		t.Fatalf("scanDocuments: %v", err)
+	}
+	if len(docs) != 1 || docs[0].Path != path {
+		t.Errorf("scanDocuments with --recursive = %v, want the payslip in the subdirectory", docs)
+	}
+}
+
+func TestProcessTwiceKeepsCorrections(t *testing.T) {
+	for _, sidecarFormat := range []string{"", "json"} {
+		dir := t.TempDir()
+		cache, err := OpenCache(filepath.Join(t.TempDir(), "cache"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Two Rückrechnungen of March issued in May, classified through the cache
+		for _, name := range []string{"a.pdf", "b.pdf"} {
+			path := filepath.Join(dir, name)
+			if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+				t.Fatal(err)
+			}
+			hash, err := fileSHA256(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			doc := Document{Path: path, Hash: hash, Type: DocumentTypePayslip, Period: Period{Year: 2024, Month: time.May},
+				Correction: &Period{Year: 2024, Month: time.March}}
+			if err := cache.Store(doc, nil, ""); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		opts := processOptions{Jobs: 1, Walk: defaultWalkOptions, Sidecar: sidecarFormat}
+		opts.Cache = cache
+		if err := processPDFs([]string{dir}, opts); err != nil {
+			t.Fatalf("processPDFs: %v", err)
+		}
+
+		plan, err := planProcess([]string{dir}, opts)
+		if err != nil {
+			t.Fatalf("planProcess: %v", err)
+		}
+		numbers := make(map[int]bool)
+		for _, file := range plan.Files {
+			if file.Target != file.Source {
+				t.Errorf("sidecar %q: second run renames %s to %s", sidecarFormat, file.Source, file.Target)
+			}
+			numbers[file.CorrectionNumber] = true
+		}
+		if !numbers[1] || !numbers[2] {
+			t.Errorf("sidecar %q: correction numbers %v, want 1 and 2", sidecarFormat, numbers)
+		}
+	}
+}
+
+func TestProcessTwiceKeepsSuffixes(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := OpenCache(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two payslips of May, e.g. a duplicate download, classified through the cache
+	for _, name := range []string{"a.pdf", "b.pdf"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := fileSHA256(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := Document{Path: path, Hash: hash, Type: DocumentTypePayslip, Period: Period{Year: 2024, Month: time.May}}
+		if err := cache.Store(doc, nil, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts := processOptions{Jobs: 1, Walk: defaultWalkOptions}
+	opts.Cache = cache
+	if err := processPDFs([]string{dir}, opts); err != nil {
+		t.Fatalf("processPDFs: %v", err)
+	}
+
+	for run := 2; run <= 3; run++ {
+		plan, err := planProcess([]string{dir}, opts)
+		if err != nil {
+			t.Fatalf("planProcess: %v", err)
+		}
+		for _, file := range plan.Files {
+			if file.Target != file.Source {
+				t.Errorf("run %d renames %s to %s", run, file.Source, file.Target)
+			}
+			if len(file.Conflicts) > 0 {
+				t.Errorf("run %d reports conflicts for %s: %v", run, file.Source, file.Conflicts)
+			}
+		}
+		if err := processPDFs([]string{dir}, opts); err != nil {
+			t.Fatalf("processPDFs: %v", err)
+		}
+	}
+}
@@ -1,213 +1,388 @@
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
+	"os/signal"
 	"path/filepath"
-	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/ledongthuc/pdf"
 	"github.com/spf13/cobra"
 )
 
+// processOptions controls how processPDFs classifies and renames documents
+type processOptions struct {
+	// DryRun only reports the plan instead of applying it
+	DryRun bool
+	// Walk selects the PDFs below the given directories
+	Walk walkOptions
+	// Jobs is the number of files extracted and classified in parallel
+	Jobs int
+	// Output is the format of the plan reported in dry runs (text, json)
+	Output string
+	// readOptions controls text extraction, OCR, decryption and the cache
+	readOptions
+	// Decrypted is where decrypted copies of password-protected PDFs are stored
+	// (alongside, replace), if set
+	Decrypted string
+	// BackupDir keeps the encrypted originals replaced by decrypted copies
+	BackupDir string
+	// Index records classifications and new paths, if set. It is read-only in dry runs.
+	Index *Index
+	// Sidecar is the format of the metadata files written next to each PDF, if set
+	Sidecar string
+	// EmbedMetadata writes the classification into each PDF's document info
+	EmbedMetadata bool
+	// Journal records the renames of the run, if set
+	Journal *Journal
+	// Written collects the files the run creates or changes, if set
+	Written *[]string
+}
+
 // NewProcessCmd creates and configures the process command
 func NewProcessCmd(config Config) *cobra.Command {
-	var pdfPath string
-	var dryRun bool
+	var pdfPaths []string
+	var indexPath string
+	var journalDir string
+	var planPath string
+	var read readFlags
+	var watch bool
+	var debounce time.Duration
+	var opts processOptions
 
 	cmd := &cobra.Command{
 		Use:   "process",
 		Short: "Process downloaded PDFs",
-		Long:  `Process all downloaded PDFs from ADP and extract relevant information.`,
+		Long: `Process all downloaded PDFs from ADP and extract relevant information.
+
+With --dry --output json the planned changes are written to stdout as a plan
+that can be reviewed, edited and then executed with --apply-plan.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			// Validate directory exists
-			if _, err := os.Stat(pdfPath); os.IsNotExist(err) {
-				log.Error("Directory does not exist", "path", pdfPath)
+			if !isPlanFormat(opts.Output) {
+				log.Error("Unsupported output format", "format", opts.Output, "supported", planFormats)
 				os.Exit(1)
 			}
+			if opts.Output != "text" && !opts.DryRun {
+				log.Error("A plan can only be written in dry run mode", "output", opts.Output)
+				os.Exit(1)
+			}
+
+			if opts.Sidecar != "" && !isSidecarFormat(opts.Sidecar) {
+				log.Error("Unsupported sidecar format", "format", opts.Sidecar, "supported", sidecarFormats)
+				os.Exit(1)
+			}
+
+			if watch && (planPath != "" || opts.DryRun) {
+				log.Error("--watch can't be combined with --dry or --apply-plan")
+				os.Exit(1)
+			}
+
+			if !isSymlinkMode(opts.Walk.Symlinks) {
+				log.Error("Unsupported symlink mode", "mode", opts.Walk.Symlinks, "supported", symlinkModes)
+				os.Exit(1)
+			}
+
+			if opts.Decrypted != "" && !isDecryptedMode(opts.Decrypted) {
+				log.Error("Unsupported decrypted mode", "mode", opts.Decrypted, "supported", decryptedModes)
+				os.Exit(1)
+			}
+
+			// Plans store filenames relative to the directories they were made for
+			var plan *Plan
+			if planPath != "" {
+				var err error
+				if plan, err = readPlan(planPath); err != nil {
+					log.Error("Error reading plan", "error", err)
+					os.Exit(1)
+				}
+				if cmd.Flags().Changed("path") {
+					if err := plan.relocate(pdfPaths); err != nil {
+						log.Error("Error relocating plan", "error", err)
+						os.Exit(1)
+					}
+				}
+				pdfPaths = plan.Paths
+			}
+
+			// Validate directories exist
+			for _, pdfPath := range pdfPaths {
+				if _, err := os.Stat(pdfPath); os.IsNotExist(err) {
+					log.Error("Directory does not exist", "path", pdfPath)
+					os.Exit(1)
+				}
+			}
 
-			log.Info("Starting PDF processing", "path", pdfPath, "dry_run", dryRun)
+			log.Info("Starting PDF processing", "path", pdfPaths, "dry_run", opts.DryRun)
 
-			// Run the processor
-			if err := processPDFs(pdfPath, dryRun); err != nil {
+			// Set up OCR, passwords and the cache. A dry run only reads an existing cache.
+			var err error
+			if opts.readOptions, err = read.readOptions(cmd, opts.DryRun); err != nil {
+				log.Error("Error setting up text extraction", "error", err)
+				os.Exit(1)
+			}
+			// Decrypted text is only cached if decrypted copies are kept anyway
+			opts.CacheDecrypted = opts.Decrypted != ""
+			opts.BackupDir = filepath.Join(config.DataDir, "backup")
+
+			// Open the document index unless disabled. A dry run only reads an
+			// existing index for the numbers of earlier Rückrechnungen.
+			if indexPath != "" {
+				var err error
+				if opts.DryRun {
+					opts.Index, err = OpenIndexReadOnly(indexPath)
+					if errors.Is(err, errNoIndex) || errors.Is(err, errIndexOutdated) {
+						err = nil
+					}
+				} else {
+					opts.Index, err = OpenIndex(indexPath)
+				}
+				if err != nil {
+					log.Error("Error opening index", "error", err)
+					os.Exit(1)
+				}
+				if opts.Index != nil {
+					defer opts.Index.Close()
+				}
+			}
+
+			// run returns the files it wrote, so that watch mode doesn't take them for new PDFs
+			run := func() ([]string, error) {
+				// Record the renames so that the run can be undone
+				if journalDir != "" && !opts.DryRun {
+					opts.Journal = newJournal(pdfPaths)
+				}
+				var written []string
+				opts.Written = &written
+
+				// Run the processor
+				var err error
+				if plan != nil {
+					err = processPlan(plan, opts)
+				} else {
+					err = processPDFs(pdfPaths, opts)
+				}
+
+				if opts.Journal != nil && len(opts.Journal.Renames) > 0 {
+					if err := opts.Journal.Save(journalDir); err != nil {
+						return written, fmt.Errorf("failed to save journal: %v", err)
+					}
+					log.Info("Recorded run", "run_id", opts.Journal.RunID, "renames", len(opts.Journal.Renames))
+				}
+				return written, err
+			}
+
+			written, err := run()
+			if err != nil {
 				log.Error("Error processing PDFs", "error", err)
 				os.Exit(1)
 			}
 
 			log.Info("All PDFs processed successfully!")
+
+			if watch {
+				// Finish the current run before stopping on SIGINT or SIGTERM
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+				defer stop()
+
+				if err := watchPDFs(ctx, pdfPaths, opts.Walk, debounce, written, run); err != nil {
+					log.Error("Error watching for PDFs", "error", err)
+					os.Exit(1)
+				}
+			}
 		},
 	}
 
-	// Add path flag
-	cmd.Flags().StringVar(&pdfPath, "path", config.DefaultDir, "Path to directory containing PDFs")
-	cmd.Flags().BoolVar(&dryRun, "dry", false, "Dry run mode")
+	// Add path and walk flags
+	addWalkFlags(cmd, config, &pdfPaths, &opts.Walk)
+	cmd.Flags().BoolVar(&opts.DryRun, "dry", false, "Dry run mode")
+	cmd.Flags().BoolVar(&watch, "watch", false, "Keep running and process new PDFs as they appear")
+	cmd.Flags().DurationVar(&debounce, "debounce", 2*time.Second, "Time a new PDF must be left unchanged before it is processed in watch mode")
+	cmd.Flags().IntVar(&opts.Jobs, "jobs", runtime.NumCPU(), "Number of PDFs to extract and classify in parallel")
+	cmd.Flags().StringVar(&opts.Output, "output", "text", "Output format of the plan in dry run mode (text, json)")
+	cmd.Flags().StringVar(&planPath, "apply-plan", "", "Execute a plan written by --dry --output json")
+	cmd.Flags().StringVar(&indexPath, "index", filepath.Join(config.DataDir, "index.db"), "Path to the document index (empty to disable)")
+	cmd.Flags().StringVar(&opts.Sidecar, "sidecar", "", "Write a metadata file next to each PDF (json, yaml)")
+	addReadFlags(cmd, config, &read)
+	cmd.Flags().StringVar(&opts.Decrypted, "store-decrypted", "", "Store decrypted copies of password-protected PDFs (alongside, replace); replace keeps the original in ~/.adp/backup for undo")
+	cmd.Flags().StringVar(&journalDir, "journal", filepath.Join(config.DataDir, "journal"), "Directory for the journals used by undo (empty to disable)")
+	cmd.Flags().BoolVar(&opts.EmbedMetadata, "embed-metadata", false, "Write type, period and employer into each PDF's document info")
 
 	return cmd
 }
 
-func processPDFs(pdfPath string, dryRun bool) error {
-	// Find all PDF files in the directory
-	pdfFiles, err := filepath.Glob(filepath.Join(pdfPath, "*.pdf"))
+// processPDFs plans the renames of all PDFs in pdfPaths and applies or reports the plan
+func processPDFs(pdfPaths []string, opts processOptions) error {
+	plan, err := planProcess(pdfPaths, opts)
 	if err != nil {
-		return fmt.Errorf("failed to list PDF files: %v", err)
+		return err
 	}
+	if opts.DryRun {
+		return reportPlan(os.Stdout, plan, opts)
+	}
+	return applyPlan(plan, opts)
+}
 
-	log.Info("Found PDF files", "count", len(pdfFiles))
-
-	// Regex to match tax certificate and extract year
-	taxCertRegex := regexp.MustCompile(`Ausdruck der elektronischen Lohnsteuerbescheinigung für (\d{4})`)
-
-	// Regex to match social insurance certificate and extract month and year
-	socialInsuranceRegex := regexp.MustCompile(`Meldebescheinigung zur Sozialversicherung`)
+// processPlan validates a reviewed plan and applies or reports it
+func processPlan(plan *Plan, opts processOptions) error {
+	if err := plan.validate(); err != nil {
+		return err
+	}
+	if opts.DryRun {
+		return reportPlan(os.Stdout, plan, opts)
+	}
+	return applyPlan(plan, opts)
+}
 
-	// Regex to match payslip and extract month and year
-	payslipRegex := regexp.MustCompile(`Verdienstabrechnung`)
+// embedProcessMetadata embeds the classification into a processed document and
+// returns its content hash, which changes if the file was rewritten
+func embedProcessMetadata(doc Document, newPath string, fields map[string]string, opts processOptions) string {
+	filename := filepath.Base(newPath)
+	changed, err := embedMetadata(newPath, pdfMetadata(doc, fields))
+	if err != nil {
+		log.Warn("Failed to embed metadata", "filename", filename, "error", err)
+		return doc.Hash
+	}
+	if !changed || doc.Hash == "" {
+		return doc.Hash
+	}
+	log.Info("Embedded metadata", "filename", filename)
 
-	// Regex to detect Rückrechnung in payslips
-	rueckrechnungRegex := regexp.MustCompile(`Rückrechnung:?\s*([A-Za-zäöüÄÖÜß]+)\s+(\d{4})`)
+	hash, err := fileSHA256(newPath)
+	if err != nil {
+		log.Warn("Failed to hash PDF", "filename", filename, "error", err)
+		return doc.Hash
+	}
+	if opts.Index != nil {
+		if err := opts.Index.UpdateHash(doc.Hash, hash); err != nil {
+			log.Warn("Failed to update index", "filename", filename, "error", err)
+		}
+	}
+	return hash
+}
 
-	// Common regex for extracting month and year from Abrechnungsmonat
-	abrechnungsmonatRegex := regexp.MustCompile(`Abrechnungsmonat:?\s*([A-Za-zäöüÄÖÜß]+)\s+(\d{4})`)
+// storeProcessDecrypted stores the decrypted copy of a processed document if it
+// is password-protected. Returns its content hash, which changes if the file was
+// replaced, the copy written alongside, if any, and the backup of a replaced original.
+func storeProcessDecrypted(doc Document, newPath string, opts processOptions) (string, string, string) {
+	filename := filepath.Base(newPath)
+	if opts.Decrypted == "replace" && doc.Hash == "" {
+		log.Warn("Not replacing PDF without content hash to back it up", "filename", filename)
+		return doc.Hash, "", ""
+	}
+	backup := filepath.Join(opts.BackupDir, doc.Hash+".pdf")
 
-	// Process each PDF file
-	for i, pdfFile := range pdfFiles {
-		filename := filepath.Base(pdfFile)
-		log.Info("Processing PDF",
-			"number", fmt.Sprintf("%d/%d", i+1, len(pdfFiles)),
-			"filename", filename)
+	encrypted, written, err := storeDecrypted(newPath, opts.Decrypted, opts.Passwords, backup)
+	if err != nil {
+		log.Warn("Failed to store decrypted PDF", "filename", filename, "error", err)
+		return doc.Hash, "", ""
+	}
+	if !encrypted || written == "" {
+		return doc.Hash, "", ""
+	}
+	if written != newPath {
+		log.Info("Stored decrypted copy", "filename", filename, "copy", filepath.Base(written))
+		return doc.Hash, written, ""
+	}
+	log.Info("Replaced with decrypted PDF", "filename", filename, "backup", backup)
 
-		// Extract text from PDF
-		text, err := extractTextFromPDF(pdfFile)
-		if err != nil {
-			log.Warn("Failed to extract text from PDF", "filename", filename, "error", err)
-			continue
+	hash, err := fileSHA256(newPath)
+	if err != nil {
+		log.Warn("Failed to hash PDF", "filename", filename, "error", err)
+		return doc.Hash, "", backup
+	}
+	if opts.Index != nil {
+		if err := opts.Index.UpdateHash(doc.Hash, hash); err != nil {
+			log.Warn("Failed to update index", "filename", filename, "error", err)
 		}
+	}
+	return hash, "", backup
+}
 
-		// Check if it's a tax certificate
-		matches := taxCertRegex.FindStringSubmatch(text)
-		if len(matches) > 1 {
-			year := matches[1]
-			newFilename := fmt.Sprintf("Lohnsteuerbescheinigung - %s.pdf", year)
-			newPath := filepath.Join(pdfPath, newFilename)
-
-			// Ensure the new filename doesn't overwrite an existing file
-			newPath = ensureUniqueFilename(newPath)
-			newFilename = filepath.Base(newPath)
-
-			log.Info("Found tax certificate",
-				"filename", filename,
-				"year", year,
-				"new_filename", newFilename)
-
-			if dryRun {
-				log.Info("Would rename", "filename", filename, "new_filename", newFilename)
-			} else {
-				if err := os.Rename(pdfFile, newPath); err != nil {
-					log.Error("Failed to rename file", "filename", filename, "error", err)
-					continue
-				}
-				log.Info("Renamed file successfully", "old", filename, "new", newFilename)
-			}
-		} else if socialInsuranceRegex.MatchString(text) {
-			// Check if it's a social insurance certificate
-			monthYearMatches := abrechnungsmonatRegex.FindStringSubmatch(text)
-			if len(monthYearMatches) > 2 {
-				month := monthYearMatches[1]
-				year := monthYearMatches[2]
-				newFilename := fmt.Sprintf("Meldebescheinigung zur Sozialversicherung - %s %s.pdf", month, year)
-				newPath := filepath.Join(pdfPath, newFilename)
-
-				// Ensure the new filename doesn't overwrite an existing file
-				newPath = ensureUniqueFilename(newPath)
-				newFilename = filepath.Base(newPath)
-
-				log.Info("Found social insurance certificate",
-					"filename", filename,
-					"month", month,
-					"year", year,
-					"new_filename", newFilename)
-
-				if dryRun {
-					log.Info("Would rename", "filename", filename, "new_filename", newFilename)
-				} else {
-					if err := os.Rename(pdfFile, newPath); err != nil {
-						log.Error("Failed to rename file", "filename", filename, "error", err)
-						continue
-					}
-					log.Info("Renamed file successfully", "old", filename, "new", newFilename)
-				}
-			} else {
-				log.Warn("Found social insurance certificate but couldn't extract month/year",
-					"filename", filename)
-			}
-		} else if payslipRegex.MatchString(text) {
-			// Check if it's a payslip
-			monthYearMatches := abrechnungsmonatRegex.FindStringSubmatch(text)
-			if len(monthYearMatches) > 2 {
-				month := monthYearMatches[1]
-				year := monthYearMatches[2]
-
-				// Check if it's a Rückrechnung
-				rueckrechnungMatches := rueckrechnungRegex.FindStringSubmatch(text)
-				var newFilename string
-				if len(rueckrechnungMatches) > 2 {
-					// It's a Rückrechnung payslip
-					rueckMonth := rueckrechnungMatches[1]
-					rueckYear := rueckrechnungMatches[2]
-					newFilename = fmt.Sprintf("Verdienstabrechnung - %s %s - Rückrechnung.pdf", rueckMonth, rueckYear)
-				} else {
-					// Regular payslip
-					newFilename = fmt.Sprintf("Verdienstabrechnung - %s %s.pdf", month, year)
-				}
-
-				newPath := filepath.Join(pdfPath, newFilename)
-
-				// Ensure the new filename doesn't overwrite an existing file
-				newPath = ensureUniqueFilename(newPath)
-				newFilename = filepath.Base(newPath)
+// writeProcessSidecar writes the sidecar of a processed document and removes
+// the one left at its previous location. Returns the path written, if any.
+func writeProcessSidecar(doc Document, newPath string, file PlannedFile, opts processOptions) string {
+	target := sidecarPath(newPath, opts.Sidecar)
+
+	var entry *IndexEntry
+	if opts.Index != nil && doc.Hash != "" {
+		if e, ok, err := opts.Index.Lookup(doc.Hash); err != nil {
+			log.Warn("Failed to look up document in index", "filename", filepath.Base(newPath), "error", err)
+		} else if ok {
+			entry = &e
+		}
+	}
 
-				log.Info("Found payslip",
-					"filename", filename,
-					"month", month,
-					"year", year,
-					"new_filename", newFilename)
+	sidecar := newSidecar(doc, file.OriginalFilename, file.Fields, entry)
+	// Keep the download link of an earlier sidecar if the index doesn't know it
+	if previous, _, ok := readSidecar(doc.Path); ok && entry == nil && previous.Hash == file.Hash {
+		sidecar.SourceLink = previous.SourceLink
+		sidecar.SourceLinkID = previous.SourceLinkID
+	}
+	if err := writeSidecar(target, opts.Sidecar, sidecar); err != nil {
+		log.Error("Failed to write sidecar", "filename", filepath.Base(target), "error", err)
+		return ""
+	}
 
-				if dryRun {
-					log.Info("Would rename", "filename", filename, "new_filename", newFilename)
-				} else {
-					if err := os.Rename(pdfFile, newPath); err != nil {
-						log.Error("Failed to rename file", "filename", filename, "error", err)
-						continue
-					}
-					log.Info("Renamed file successfully", "old", filename, "new", newFilename)
+	// Remove sidecars left next to the old filename
+	if newPath != doc.Path {
+		for _, format := range sidecarFormats {
+			old := sidecarPath(doc.Path, format)
+			if old != target {
+				if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+					log.Warn("Failed to remove old sidecar", "filename", filepath.Base(old), "error", err)
 				}
-			} else {
-				log.Warn("Found payslip but couldn't extract month/year",
-					"filename", filename)
 			}
-		} else {
-			log.Info("Not a recognized certificate type", "filename", filename)
 		}
 	}
-
-	return nil
+	return target
 }
 
-// ensureUniqueFilename ensures the given path doesn't overwrite an existing file
-// by adding "_2" suffix if needed
-func ensureUniqueFilename(path string) string {
+// ensureUniqueFilename ensures the given path doesn't overwrite an existing or
+// already planned file by adding a "_2", "_3", ... suffix if needed. The source
+// file doesn't take its own path, and keeps a suffix it already has for the same
+// base, so that processing again renames nothing.
+func ensureUniqueFilename(path, source string, planned map[string]bool) string {
+	taken := func(path string) bool {
+		if planned[path] {
+			return true
+		}
+		if path == source {
+			return false
+		}
+		_, err := os.Stat(path)
+		return !os.IsNotExist(err)
+	}
+
 	// If the file doesn't exist, return the original path
-	if _, err := os.Stat(path); os.IsNotExist(err) {
+	if !taken(path) {
 		return path
 	}
 
-	// File exists, add "_2" suffix
+	// File exists, keep the suffix of an earlier run
 	ext := filepath.Ext(path)
 	basePath := path[:len(path)-len(ext)]
-	return fmt.Sprintf("%s_2%s", basePath, ext)
+	if suffix, ok := strings.CutPrefix(source, basePath+"_"); ok && !planned[source] {
+		if n, err := strconv.Atoi(strings.TrimSuffix(suffix, ext)); err == nil && n >= 2 && strings.HasSuffix(suffix, ext) {
+			return source
+		}
+	}
+
+	// Otherwise add a numbered suffix
+	for n := 2; ; n++ {
+		candidate := fmt.Sprintf("%s_%d%s", basePath, n, ext)
+		if !taken(candidate) {
+			return candidate
+		}
+	}
 }
 
 // extractTextFromPDF extracts text content from a PDF file
@@ -0,0 +1,412 @@
+package cmd
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// reportFormats lists the supported report formats
+var reportFormats = []string{"table", "markdown", "html"}
+
+// reportTable is a titled table of a report
+type reportTable struct {
+	Title  string
+	Header []string
+	Rows   [][]string
+	Footer []string
+}
+
+// reportDoc is a titled document of tables rendered by renderReport, e.g. the
+// summary of a year or the outcome of the doctor checks
+type reportDoc struct {
+	Title  string
+	Notes  []string
+	Tables []reportTable
+}
+
+// NewReportCmd creates and configures the report command
+func NewReportCmd(config Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "report",
+		Short: "Summarize processed documents",
+		Long:  `Generate human-readable reports from the payslips and certificates in the archive.`,
+	}
+
+	cmd.AddCommand(NewReportYearCmd(config))
+
+	return cmd
+}
+
+// NewReportYearCmd creates and configures the report year command
+func NewReportYearCmd(config Config) *cobra.Command {
+	var (
+		pdfPaths   []string
+		walk       walkOptions
+		format     string
+		outputPath string
+		read       readFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "year <YYYY>",
+		Short: "Annual tax summary",
+		Long: `Aggregate all payslips of a year and the Lohnsteuerbescheinigung of the same
+year into a report with monthly gross and net pay, total taxes and contributions,
+and the values to enter into the Anlage N and the Anlage Vorsorgeaufwand.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			year, err := strconv.Atoi(args[0])
+			if err != nil || len(args[0]) != 4 {
+				log.Error("Invalid year", "year", args[0])
+				os.Exit(1)
+			}
+
+			if !isReportFormat(format) {
+				log.Error("Unsupported report format", "format", format, "supported", reportFormats)
+				os.Exit(1)
+			}
+
+			if err := checkWalkFlags(pdfPaths, walk); err != nil {
+				log.Error("Invalid PDF selection", "error", err)
+				os.Exit(1)
+			}
+
+			opts, err := read.readOptions(cmd, false)
+			if err != nil {
+				log.Error("Error setting up text extraction", "error", err)
+				os.Exit(1)
+			}
+
+			docs, err := loadDocuments(pdfPaths, walk, opts)
+			if err != nil {
+				log.Error("Error loading documents", "error", err)
+				os.Exit(1)
+			}
+
+			report := buildYearReport(year, docs)
+
+			var out io.Writer = os.Stdout
+			if outputPath != "" {
+				f, err := os.Create(outputPath)
+				if err != nil {
+					log.Error("Failed to create output file", "path", outputPath, "error", err)
+					os.Exit(1)
+				}
+				defer f.Close()
+				out = f
+			}
+
+			if err := renderReport(out, format, report); err != nil {
+				log.Error("Error rendering report", "error", err)
+				os.Exit(1)
+			}
+		},
+	}
+
+	addWalkFlags(cmd, config, &pdfPaths, &walk)
+	cmd.Flags().StringVar(&format, "format", "table", "Output format (table, markdown, html)")
+	cmd.Flags().StringVar(&outputPath, "out-file", "", "Write to file instead of stdout")
+	addReadFlags(cmd, config, &read)
+
+	return cmd
+}
+
+func isReportFormat(format string) bool {
+	for _, f := range reportFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// findTaxCertificate returns the parsed Lohnsteuerbescheinigung for a year, if any.
+// Several certificates of a year, e.g. a corrected one next to the original, can't
+// be told apart by their content; the first in document order is used and all of
+// them are named in a warning.
+func findTaxCertificate(year int, docs []Document) (*TaxCertificate, error) {
+	var found []Document
+	for _, doc := range docs {
+		if doc.Type == DocumentTypeTaxCertificate && doc.Period.Year == year {
+			found = append(found, doc)
+		}
+	}
+	if len(found) == 0 {
+		return nil, nil
+	}
+	if len(found) > 1 {
+		paths := make([]string, len(found))
+		for i, doc := range found {
+			paths[i] = filepath.Base(doc.Path)
+		}
+		log.Warn("Several Lohnsteuerbescheinigungen found, using the first", "year", year, "files", paths, "using", paths[0])
+	}
+
+	certificate, err := parseTaxCertificate(found[0])
+	if err != nil {
+		return nil, err
+	}
+	return &certificate, nil
+}
+
+// payslipsForYear returns the effective payslips whose amounts apply to the given year
+func payslipsForYear(year int, docs []Document) []Payslip {
+	var payslips []Payslip
+	for _, payslip := range effectivePayslips(parsePayslips(docs)) {
+		if payslip.EffectivePeriod().Year == year {
+			payslips = append(payslips, payslip)
+		}
+	}
+	return payslips
+}
+
+func buildYearReport(year int, docs []Document) reportDoc {
+	report := reportDoc{Title: fmt.Sprintf("Jahresübersicht %d", year)}
+
+	payslips := payslipsForYear(year, docs)
+	if len(payslips) == 0 {
+		report.Notes = append(report.Notes, fmt.Sprintf("No payslips found for %d.", year))
+	}
+
+	// Monthly overview
+	monthly := reportTable{
+		Title:  "Monthly overview",
+		Header: []string{"Month", "Gross", "Taxes", "Social insurance", "Net", "Payout", "Note"},
+	}
+	var total Payslip
+	for _, payslip := range payslips {
+		note := ""
+		if payslip.IsCorrection() {
+			note = fmt.Sprintf("Rückrechnung %d from %s", payslip.CorrectionNumber, payslip.Period)
+		}
+		monthly.Rows = append(monthly.Rows, []string{
+			payslip.EffectivePeriod().String(),
+			payslip.Gross.German(),
+			payslip.Taxes().German(),
+			payslip.SocialInsurance().German(),
+			payslip.Net.German(),
+			payslip.Payout.German(),
+			note,
+		})
+		total = total.plus(payslip)
+	}
+	monthly.Footer = []string{
+		"Total",
+		total.Gross.German(),
+		total.Taxes().German(),
+		total.SocialInsurance().German(),
+		total.Net.German(),
+		total.Payout.German(),
+		"",
+	}
+	report.Tables = append(report.Tables, monthly)
+
+	// Totals of taxes and contributions
+	report.Tables = append(report.Tables, reportTable{
+		Title:  "Taxes and contributions",
+		Header: []string{"Item", "Total"},
+		Rows: [][]string{
+			{"Lohnsteuer", total.IncomeTax.German()},
+			{"Solidaritätszuschlag", total.SolidaritySurcharge.German()},
+			{"Kirchensteuer", total.ChurchTax.German()},
+			{"Krankenversicherung", total.HealthInsurance.German()},
+			{"Rentenversicherung", total.PensionInsurance.German()},
+			{"Arbeitslosenversicherung", total.UnemploymentInsurance.German()},
+			{"Pflegeversicherung", total.CareInsurance.German()},
+		},
+		Footer: []string{"Total", (total.Taxes() + total.SocialInsurance()).German()},
+	})
+
+	// Values for the tax return, preferring the Lohnsteuerbescheinigung
+	certificate, err := findTaxCertificate(year, docs)
+	if err != nil {
+		log.Warn("Failed to parse Lohnsteuerbescheinigung", "year", year, "error", err)
+	}
+	employerPension := "n/a"
+	switch {
+	case err != nil:
+		report.Notes = append(report.Notes, fmt.Sprintf(
+			"The Lohnsteuerbescheinigung for %d couldn't be parsed (%v); tax return values are summed from the payslips.", year, err))
+	case certificate == nil:
+		report.Notes = append(report.Notes, fmt.Sprintf(
+			"No Lohnsteuerbescheinigung for %d found; tax return values are summed from the payslips.", year))
+	default:
+		employerPension = certificate.EmployerPensionInsurance.German()
+	}
+	if certificate == nil {
+		// Payslips don't show the employer's share of the pension insurance, and
+		// their Steuer-Brutto stands in for Nr. 3, which excludes tax-free pay
+		report.Notes = append(report.Notes, "Arbeitgeberanteil Rentenversicherung (Nr. 22a) is only known from the Lohnsteuerbescheinigung.")
+		certificate = &TaxCertificate{
+			GrossWage:             total.TaxableGross,
+			IncomeTax:             total.IncomeTax,
+			SolidaritySurcharge:   total.SolidaritySurcharge,
+			ChurchTax:             total.ChurchTax,
+			PensionInsurance:      total.PensionInsurance,
+			HealthInsurance:       total.HealthInsurance,
+			CareInsurance:         total.CareInsurance,
+			UnemploymentInsurance: total.UnemploymentInsurance,
+		}
+	}
+
+	report.Tables = append(report.Tables, reportTable{
+		Title:  "Anlage N",
+		Header: []string{"Entry", "Lohnsteuerbescheinigung", "Amount"},
+		Rows: [][]string{
+			{"Bruttoarbeitslohn", "Nr. 3", certificate.GrossWage.German()},
+			{"Lohnsteuer", "Nr. 4", certificate.IncomeTax.German()},
+			{"Solidaritätszuschlag", "Nr. 5", certificate.SolidaritySurcharge.German()},
+			{"Kirchensteuer Arbeitnehmer", "Nr. 6", certificate.ChurchTax.German()},
+		},
+	})
+
+	report.Tables = append(report.Tables, reportTable{
+		Title:  "Anlage Vorsorgeaufwand",
+		Header: []string{"Entry", "Lohnsteuerbescheinigung", "Amount"},
+		Rows: [][]string{
+			{"Arbeitnehmeranteil Rentenversicherung", "Nr. 23a", certificate.PensionInsurance.German()},
+			{"Arbeitgeberanteil Rentenversicherung", "Nr. 22a", employerPension},
+			{"Beiträge zur gesetzlichen Krankenversicherung", "Nr. 25", certificate.HealthInsurance.German()},
+			{"Beiträge zur sozialen Pflegeversicherung", "Nr. 26", certificate.CareInsurance.German()},
+			{"Beiträge zur Arbeitslosenversicherung", "Nr. 27", certificate.UnemploymentInsurance.German()},
+		},
+	})
+
+	return report
+}
+
+// renderReport writes the report in the given format
+func renderReport(w io.Writer, format string, report reportDoc) error {
+	switch format {
+	case "table":
+		return renderReportTable(w, report)
+	case "markdown":
+		return renderReportMarkdown(w, report)
+	case "html":
+		return reportHTMLTemplate.Execute(w, report)
+	default:
+		return fmt.Errorf("unsupported format: %s", format)
+	}
+}
+
+// Regex to detect cells holding amounts or numbers
+var numericCellRegex = regexp.MustCompile(`^(?:` + germanLocale.Amount.String() + `|\d+[a-z]?)$`)
+
+func renderReportTable(w io.Writer, report reportDoc) error {
+	fmt.Fprintf(w, "%s\n%s\n", report.Title, strings.Repeat("=", len([]rune(report.Title))))
+	for _, note := range report.Notes {
+		fmt.Fprintf(w, "! %s\n", note)
+	}
+
+	for _, table := range report.Tables {
+		fmt.Fprintf(w, "\n%s\n%s\n", table.Title, strings.Repeat("-", len([]rune(table.Title))))
+
+		if err := renderReportRows(w, table); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderReportRows writes the header, rows and footer of a table as aligned text
+func renderReportRows(w io.Writer, table reportTable) error {
+	rows := append([][]string{table.Header}, table.Rows...)
+	if table.Footer != nil {
+		rows = append(rows, table.Footer)
+	}
+	widths := make([]int, len(table.Header))
+	for _, row := range rows {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len([]rune(cell)))
+		}
+	}
+	for _, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			// Text is left-aligned, amounts are right-aligned
+			if !numericCellRegex.MatchString(cell) {
+				cells[i] = fmt.Sprintf("%-*s", widths[i], cell)
+			} else {
+				cells[i] = fmt.Sprintf("%*s", widths[i], cell)
+			}
+		}
+		if _, err := fmt.Fprintln(w, strings.TrimRight(strings.Join(cells, "  "), " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func renderReportMarkdown(w io.Writer, report reportDoc) error {
+	fmt.Fprintf(w, "# %s\n", report.Title)
+	if len(report.Notes) > 0 {
+		fmt.Fprintln(w)
+		for _, note := range report.Notes {
+			fmt.Fprintf(w, "> %s\n", note)
+		}
+	}
+
+	for _, table := range report.Tables {
+		fmt.Fprintf(w, "\n## %s\n\n", table.Title)
+		fmt.Fprintf(w, "| %s |\n", strings.Join(table.Header, " | "))
+
+		alignments := make([]string, len(table.Header))
+		for i := range alignments {
+			alignments[i] = "---:"
+		}
+		alignments[0] = "---"
+		fmt.Fprintf(w, "|%s|\n", strings.Join(alignments, "|"))
+
+		for _, row := range table.Rows {
+			fmt.Fprintf(w, "| %s |\n", strings.Join(row, " | "))
+		}
+		if table.Footer != nil {
+			bold := make([]string, len(table.Footer))
+			for i, cell := range table.Footer {
+				if cell != "" {
+					bold[i] = "**" + cell + "**"
+				}
+			}
+			fmt.Fprintf(w, "| %s |\n", strings.Join(bold, " | "))
+		}
+	}
+	return nil
+}
+
+var reportHTMLTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html lang="de">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.8em; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+tfoot td { font-weight: bold; }
+.note { color: #a15c00; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Notes}}<p class="note">{{.}}</p>
+{{end}}{{range .Tables}}<h2>{{.Title}}</h2>
+<table>
+<thead><tr>{{range .Header}}<th>{{.}}</th>{{end}}</tr></thead>
+<tbody>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</tbody>
+{{if .Footer}}<tfoot><tr>{{range .Footer}}<td>{{.}}</td>{{end}}</tr></tfoot>
+{{end}}</table>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testReport is a report with a note, numeric and text cells and a footer
+var testReport = reportDoc{
+	Title: "Jahresübersicht 2023",
+	Notes: []string{"No Lohnsteuerbescheinigung for 2023 found."},
+	Tables: []reportTable{{
+		Title:  "Monthly overview",
+		Header: []string{"Month", "Gross", "Note"},
+		Rows: [][]string{
+			{"Januar 2023", "5.000,00", ""},
+			{"Februar 2023", "500,00", "<Rückrechnung>"},
+		},
+		Footer: []string{"Total", "5.500,00", ""},
+	}},
+}
+
+func TestRenderReport(t *testing.T) {
+	tests := []struct {
+		format string
+		want   []string
+	}{
+		{"table", []string{
+			"Jahresübersicht 2023\n====================\n",
+			"! No Lohnsteuerbescheinigung for 2023 found.\n",
+			"\nMonthly overview\n----------------\n",
+			"Month         Gross     Note\n",
+			"Januar 2023   5.000,00\n",
+			"Februar 2023    500,00  <Rückrechnung>\n",
+			"Total         5.500,00\n",
+		}},
+		{"markdown", []string{
+			"# Jahresübersicht 2023\n",
+			"> No Lohnsteuerbescheinigung for 2023 found.\n",
+			"## Monthly overview\n\n| Month | Gross | Note |\n|---|---:|---:|\n",
+			"| Februar 2023 | 500,00 | <Rückrechnung> |\n",
+			"| **Total** | **5.500,00** |  |\n",
+		}},
+		{"html", []string{
+			"<title>Jahresübersicht 2023</title>",
+			`<p class="note">No Lohnsteuerbescheinigung for 2023 found.</p>`,
+			"<thead><tr><th>Month</th><th>Gross</th><th>Note</th></tr></thead>",
+			"<tr><td>Februar 2023</td><td>500,00</td><td>&lt;Rückrechnung&gt;</td></tr>",
+			"<tfoot><tr><td>Total</td><td>5.500,00</td><td></td></tr></tfoot>",
+		}},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := renderReport(&buf, tt.format, testReport); err != nil {
+			t.Fatalf("renderReport(%s): %v", tt.format, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(buf.String(), want) {
+				t.Errorf("%s output lacks %q:\n%s", tt.format, want, buf.String())
+			}
+		}
+	}
+
+	if err := renderReport(&bytes.Buffer{}, "pdf", testReport); err == nil {
+		t.Error("renderReport accepted an unsupported format")
+	}
+}
+
+func TestBuildYearReport(t *testing.T) {
+	var docs []Document
+	for _, month := range []string{"Januar", "Februar"} {
+		doc, err := classifyDocument(month+".pdf", fmt.Sprintf("Verdienstabrechnung\nAbrechnungsmonat: %s 2023\n"+
+			"Gesamtbrutto 5.000,00\nSteuer-Brutto 4.900,00\nLohnsteuer 1.000,00\nNettoverdienst 3.900,00\n", month), nil)
+		if err != nil {
+			t.Fatalf("classifyDocument(%s): %v", month, err)
+		}
+		docs = append(docs, doc)
+	}
+
+	report := buildYearReport(2023, docs)
+	if report.Title != "Jahresübersicht 2023" {
+		t.Errorf("title = %q", report.Title)
+	}
+	if len(report.Tables) != 4 {
+		t.Fatalf("got %d tables, want monthly overview, totals, Anlage N and Vorsorgeaufwand", len(report.Tables))
+	}
+	monthly := report.Tables[0]
+	if len(monthly.Rows) != 2 || monthly.Footer[1] != "10.000,00" {
+		t.Errorf("monthly overview = %v, footer %v, want two months totalling 10.000,00", monthly.Rows, monthly.Footer)
+	}
+	// Without a certificate Nr. 3 is the Steuer-Brutto summed from the payslips
+	if row := report.Tables[2].Rows[0]; row[1] != "Nr. 3" || row[2] != "9.800,00" {
+		t.Errorf("Anlage N = %v, want Nr. 3 of 9.800,00", row)
+	}
+	if len(report.Notes) == 0 || !strings.Contains(report.Notes[0], "No Lohnsteuerbescheinigung for 2023") {
+		t.Errorf("notes = %v, want a missing certificate note", report.Notes)
+	}
+}
+
+func TestFindTaxCertificateUsesFirst(t *testing.T) {
+	var docs []Document
+	for i, gross := range []string{"58.800,00", "59.000,00"} {
+		doc, err := classifyDocument(fmt.Sprintf("certificate-%d.pdf", i), "Ausdruck der elektronischen Lohnsteuerbescheinigung für 2023\n"+
+			"3. Bruttoarbeitslohn "+gross+"\n", nil)
+		if err != nil {
+			t.Fatalf("classifyDocument: %v", err)
+		}
+		docs = append(docs, doc)
+	}
+
+	certificate, err := findTaxCertificate(2023, docs)
+	if err != nil {
+		t.Fatalf("findTaxCertificate: %v", err)
+	}
+	if certificate == nil || certificate.GrossWage != 5880000 {
+		t.Errorf("findTaxCertificate = %+v, want the first certificate", certificate)
+	}
+	if certificate, err := findTaxCertificate(2022, docs); certificate != nil || err != nil {
+		t.Errorf("findTaxCertificate(2022) = %v, %v, want none", certificate, err)
+	}
+}
@@ -53,6 +53,14 @@ It can download PDFs from adpworld.adp.com and process them locally.`,
 	// Add subcommands
 	rootCmd.AddCommand(NewDownloadCmd(config))
 	rootCmd.AddCommand(NewProcessCmd(config))
+	rootCmd.AddCommand(NewUndoCmd(config))
+	rootCmd.AddCommand(NewExportCmd(config))
+	rootCmd.AddCommand(NewReportCmd(config))
+	rootCmd.AddCommand(NewVerifyCmd(config))
+	rootCmd.AddCommand(NewAnalyzeCmd(config))
+	rootCmd.AddCommand(NewLsCmd(config))
+	rootCmd.AddCommand(NewSearchCmd(config))
+	rootCmd.AddCommand(NewDoctorCmd(config))
 
 	return rootCmd
 }
@@ -0,0 +1,510 @@
+package cmd
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/charmbracelet/log"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+)
+
+// BM25 ranking parameters
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// snippetRadius is the number of characters shown around the first match
+const snippetRadius = 60
+
+// SearchResult is a document matching a search query
+type SearchResult struct {
+	Hash       string
+	Path       string
+	Type       DocumentType
+	Period     Period
+	Correction *Period
+	Score      float64
+	Snippet    string
+}
+
+// NewSearchCmd creates and configures the search command
+func NewSearchCmd(config Config) *cobra.Command {
+	var (
+		pdfPaths     []string
+		walk         walkOptions
+		indexPath    string
+		documentType string
+		year         int
+		limit        int
+		sortBy       string
+		read         readFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Search the text of all archived documents",
+		Long: `Search the text extracted from every PDF. The search index is updated
+incrementally before each search, so only new or changed files are parsed.
+All query terms must match; a trailing * matches any word with that prefix,
+e.g. "Dienstwagen*".`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			filter := IndexFilter{Year: year}
+			if documentType != "" {
+				t, ok := documentTypeNames[documentType]
+				if !ok {
+					log.Error("Unknown document type", "type", documentType, "supported", sortedKeys(documentTypeNames))
+					os.Exit(1)
+				}
+				filter.Type = t
+			}
+			if sortBy != "score" && sortBy != "date" {
+				log.Error("Unsupported sort order", "sort", sortBy, "supported", []string{"score", "date"})
+				os.Exit(1)
+			}
+
+			// Missing directories are skipped below instead of failing the search
+			if err := checkWalkFlags(nil, walk); err != nil {
+				log.Error("Invalid PDF selection", "error", err)
+				os.Exit(1)
+			}
+
+			opts, err := read.readOptions(cmd, false)
+			if err != nil {
+				log.Error("Error setting up text extraction", "error", err)
+				os.Exit(1)
+			}
+
+			index, err := OpenIndex(indexPath)
+			if err != nil {
+				log.Error("Error opening index", "error", err)
+				os.Exit(1)
+			}
+			defer index.Close()
+
+			// Missing directories only leave their documents out of the update
+			var existing []string
+			for _, pdfPath := range pdfPaths {
+				if _, err := os.Stat(pdfPath); err == nil {
+					existing = append(existing, pdfPath)
+				} else {
+					log.Warn("Directory does not exist, searching existing index only", "path", pdfPath)
+				}
+			}
+			if len(existing) > 0 {
+				if err := index.UpdateSearchIndex(existing, walk, opts); err != nil {
+					log.Error("Error updating search index", "error", err)
+					os.Exit(1)
+				}
+			}
+
+			results, err := index.Search(strings.Join(args, " "), filter)
+			if err != nil {
+				log.Error("Error searching documents", "error", err)
+				os.Exit(1)
+			}
+			if sortBy == "date" {
+				sort.SliceStable(results, func(i, j int) bool {
+					return results[i].Period.Before(results[j].Period)
+				})
+			}
+			if limit > 0 && len(results) > limit {
+				results = results[:limit]
+			}
+
+			highlight := isatty.IsTerminal(os.Stdout.Fd())
+			writeSearchResults(os.Stdout, results, highlight)
+			log.Info("Search complete", "matches", len(results))
+		},
+	}
+
+	addWalkFlags(cmd, config, &pdfPaths, &walk)
+	cmd.Flags().StringVar(&indexPath, "index", filepath.Join(config.DataDir, "index.db"), "Path to the document index")
+	cmd.Flags().StringVar(&documentType, "type", "", "Only search documents of this type (payslip, tax-certificate, social-insurance)")
+	cmd.Flags().IntVar(&year, "year", 0, "Only search documents of this year")
+	cmd.Flags().IntVar(&limit, "limit", 20, "Maximum number of results (0 for all)")
+	cmd.Flags().StringVar(&sortBy, "sort", "score", "Order results by score or date")
+	addReadFlags(cmd, config, &read)
+
+	return cmd
+}
+
+// searchTerm is a word of a search query
+type searchTerm struct {
+	text string
+	// prefix matches any word starting with text
+	prefix bool
+}
+
+// matches reports whether the term matches a token returned by tokenize
+func (t searchTerm) matches(token string) bool {
+	if t.prefix {
+		return strings.HasPrefix(token, t.text)
+	}
+	return token == t.text
+}
+
+// isTokenRune reports whether a character belongs to a word
+func isTokenRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// tokenize splits text into lowercase words of at least two letters or digits
+func tokenize(text string) []string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !isTokenRune(r)
+	})
+	tokens := words[:0]
+	for _, word := range words {
+		if len([]rune(word)) >= 2 {
+			tokens = append(tokens, word)
+		}
+	}
+	return tokens
+}
+
+// UpdateSearchIndex adds new and changed PDFs the walk options select in the
+// directories to the search index and drops entries whose files no longer exist.
+// Text is read like for every other command, including decryption, OCR and the cache.
+func (ix *Index) UpdateSearchIndex(pdfPaths []string, walk walkOptions, opts readOptions) error {
+	pdfFiles, err := findPDFs(pdfPaths, walk)
+	if err != nil {
+		return fmt.Errorf("failed to list PDF files: %v", err)
+	}
+
+	added := 0
+	for _, found := range pdfFiles {
+		pdfFile := found.Path
+		filename := filepath.Base(pdfFile)
+
+		hash, err := fileSHA256(pdfFile)
+		if err != nil {
+			log.Warn("Failed to hash PDF", "filename", filename, "error", err)
+			continue
+		}
+
+		// Unchanged content only needs its path refreshed if it was moved. Of
+		// identical copies the one indexed first is kept, so that results don't
+		// flip between them.
+		var stored string
+		err = ix.db.QueryRow(`SELECT path FROM search_documents WHERE hash = ?`, hash).Scan(&stored)
+		if err == nil {
+			if _, err := os.Stat(stored); stored != pdfFile && os.IsNotExist(err) {
+				if _, err := ix.db.Exec(`UPDATE search_documents SET path = ? WHERE hash = ?`, pdfFile, hash); err != nil {
+					return fmt.Errorf("failed to update search index: %v", err)
+				}
+			}
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("failed to read search index: %v", err)
+		}
+
+		doc, _, err := readDocument(pdfFile, hash, opts)
+		if doc.Type == DocumentTypeUnknown && err != nil {
+			log.Warn("Failed to read PDF", "filename", filename, "error", err)
+			continue
+		}
+		// The text of password-protected PDFs isn't stored in plaintext unless
+		// a decrypted copy is kept anyway
+		if doc.Decrypted {
+			log.Debug("Indexing password-protected PDF without text", "filename", filename)
+			doc.Text = ""
+		}
+
+		// Replace the previous content of a file that was changed in place
+		var previous string
+		if err := ix.db.QueryRow(`SELECT hash FROM search_documents WHERE path = ?`, pdfFile).Scan(&previous); err == nil {
+			if err := ix.removeSearchDocument(previous); err != nil {
+				return err
+			}
+		}
+
+		if err := ix.addSearchDocument(doc); err != nil {
+			return err
+		}
+		added++
+	}
+
+	// Drop documents that were deleted or replaced
+	rows, err := ix.db.Query(`SELECT hash, path FROM search_documents`)
+	if err != nil {
+		return fmt.Errorf("failed to read search index: %v", err)
+	}
+	var stale []string
+	for rows.Next() {
+		var hash, path string
+		if err := rows.Scan(&hash, &path); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to read search index: %v", err)
+		}
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			stale = append(stale, hash)
+		}
+	}
+	rows.Close()
+	for _, hash := range stale {
+		if err := ix.removeSearchDocument(hash); err != nil {
+			return err
+		}
+	}
+
+	log.Debug("Updated search index", "added", added, "removed", len(stale))
+	return nil
+}
+
+func (ix *Index) addSearchDocument(doc Document) error {
+	tokens := tokenize(doc.Text)
+	frequencies := make(map[string]int)
+	for _, token := range tokens {
+		frequencies[token]++
+	}
+
+	var correction Period
+	if doc.Correction != nil {
+		correction = *doc.Correction
+	}
+
+	tx, err := ix.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to update search index: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM postings WHERE hash = ?`, doc.Hash); err != nil {
+		return fmt.Errorf("failed to update search index: %v", err)
+	}
+	if _, err := tx.Exec(`
+		INSERT OR REPLACE INTO search_documents (hash, path, type, year, month, correction_year, correction_month, length, text)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		doc.Hash, doc.Path, string(doc.Type), doc.Period.Year, int(doc.Period.Month),
+		correction.Year, int(correction.Month), len(tokens), doc.Text); err != nil {
+		return fmt.Errorf("failed to update search index: %v", err)
+	}
+
+	stmt, err := tx.Prepare(`INSERT INTO postings (term, hash, tf) VALUES (?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("failed to update search index: %v", err)
+	}
+	defer stmt.Close()
+	for term, tf := range frequencies {
+		if _, err := stmt.Exec(term, doc.Hash, tf); err != nil {
+			return fmt.Errorf("failed to update search index: %v", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+func (ix *Index) removeSearchDocument(hash string) error {
+	if _, err := ix.db.Exec(`DELETE FROM postings WHERE hash = ?`, hash); err != nil {
+		return fmt.Errorf("failed to update search index: %v", err)
+	}
+	if _, err := ix.db.Exec(`DELETE FROM search_documents WHERE hash = ?`, hash); err != nil {
+		return fmt.Errorf("failed to update search index: %v", err)
+	}
+	return nil
+}
+
+// Search returns the documents containing all query terms, ranked by BM25
+func (ix *Index) Search(query string, filter IndexFilter) ([]SearchResult, error) {
+	var terms []searchTerm
+	for _, field := range strings.Fields(query) {
+		prefix := strings.HasSuffix(field, "*")
+		for _, token := range tokenize(field) {
+			terms = append(terms, searchTerm{text: token, prefix: prefix})
+		}
+	}
+	if len(terms) == 0 {
+		return nil, fmt.Errorf("query %q contains no searchable words", query)
+	}
+
+	var documentCount int
+	var averageLength float64
+	if err := ix.db.QueryRow(`SELECT COUNT(*), COALESCE(AVG(length), 0) FROM search_documents`).Scan(&documentCount, &averageLength); err != nil {
+		return nil, fmt.Errorf("failed to read search index: %v", err)
+	}
+	if documentCount == 0 {
+		return nil, nil
+	}
+
+	// Collect term frequencies per document, keeping only documents matching all terms
+	var candidates map[string][]int
+	var documentFrequencies []int
+	for i, term := range terms {
+		condition, arg := "term = ?", term.text
+		if term.prefix {
+			condition, arg = "term GLOB ?", term.text+"*"
+		}
+		rows, err := ix.db.Query(`SELECT hash, SUM(tf) FROM postings WHERE `+condition+` GROUP BY hash`, arg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query search index: %v", err)
+		}
+		matches := make(map[string]int)
+		for rows.Next() {
+			var hash string
+			var tf int
+			if err := rows.Scan(&hash, &tf); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to query search index: %v", err)
+			}
+			matches[hash] = tf
+		}
+		rows.Close()
+		documentFrequencies = append(documentFrequencies, len(matches))
+
+		next := make(map[string][]int)
+		for hash, tf := range matches {
+			if i == 0 {
+				next[hash] = []int{tf}
+			} else if tfs, ok := candidates[hash]; ok {
+				next[hash] = append(tfs, tf)
+			}
+		}
+		candidates = next
+	}
+
+	var results []SearchResult
+	for hash, tfs := range candidates {
+		var (
+			result              SearchResult
+			documentType, text  string
+			year, month, length int
+			corrYear, corrMonth int
+		)
+		if err := ix.db.QueryRow(`
+			SELECT path, type, year, month, correction_year, correction_month, length, text
+			FROM search_documents WHERE hash = ?`, hash).Scan(
+			&result.Path, &documentType, &year, &month, &corrYear, &corrMonth, &length, &text); err != nil {
+			return nil, fmt.Errorf("failed to read search index: %v", err)
+		}
+
+		result.Hash = hash
+		result.Type = DocumentType(documentType)
+		result.Period = Period{Year: year, Month: time.Month(month)}
+		if corrYear != 0 {
+			result.Correction = &Period{Year: corrYear, Month: time.Month(corrMonth)}
+		}
+
+		if filter.Type != DocumentTypeUnknown && result.Type != filter.Type {
+			continue
+		}
+		if filter.Year != 0 && year != filter.Year && corrYear != filter.Year {
+			continue
+		}
+		if filter.Correction && result.Correction == nil {
+			continue
+		}
+
+		for i, tf := range tfs {
+			idf := math.Log(1 + (float64(documentCount)-float64(documentFrequencies[i])+0.5)/(float64(documentFrequencies[i])+0.5))
+			norm := float64(tf) + bm25K1*(1-bm25B+bm25B*float64(length)/averageLength)
+			result.Score += idf * float64(tf) * (bm25K1 + 1) / norm
+		}
+
+		result.Snippet = snippet(text, terms)
+		results = append(results, result)
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Path < results[j].Path
+	})
+	return results, nil
+}
+
+// snippet returns the text around the first word matching any of the terms,
+// with all matching words marked by \x00 and \x01. Words are delimited like by
+// tokenize, so a prefix only matches at the start of a word.
+func snippet(text string, terms []searchTerm) string {
+	runes := []rune(text)
+
+	// Find the words matching a term
+	type span struct{ start, end int }
+	var matches []span
+	for i := 0; i < len(runes); {
+		if !isTokenRune(runes[i]) {
+			i++
+			continue
+		}
+		j := i
+		for j < len(runes) && isTokenRune(runes[j]) {
+			j++
+		}
+		token := strings.ToLower(string(runes[i:j]))
+		for _, term := range terms {
+			if term.matches(token) {
+				matches = append(matches, span{i, j})
+				break
+			}
+		}
+		i = j
+	}
+
+	first := 0
+	if len(matches) > 0 {
+		first = matches[0].start
+	}
+	start := max(0, first-snippetRadius)
+	end := min(len(runes), first+snippetRadius)
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	// Matches are kept whole, even if they extend past the end
+	i := start
+	for _, match := range matches {
+		if match.start >= end {
+			break
+		}
+		b.WriteString(string(runes[i:match.start]))
+		b.WriteRune('\x00')
+		b.WriteString(string(runes[match.start:match.end]))
+		b.WriteRune('\x01')
+		i = match.end
+	}
+	if i < end {
+		b.WriteString(string(runes[i:end]))
+	}
+	if max(i, end) < len(runes) {
+		b.WriteString("…")
+	}
+
+	return strings.Join(strings.Fields(b.String()), " ")
+}
+
+// writeSearchResults prints the results, highlighting matches with ANSI bold if requested
+func writeSearchResults(w io.Writer, results []SearchResult, highlight bool) {
+	open, close := "*", "*"
+	if highlight {
+		open, close = "\x1b[1m", "\x1b[0m"
+	}
+
+	for i, result := range results {
+		period := result.Period.String()
+		if result.Correction != nil {
+			period = fmt.Sprintf("%s (Rückrechnung %s)", period, result.Correction)
+		}
+		if result.Period.IsZero() {
+			period = "-"
+		}
+		fmt.Fprintf(w, "%d. %s  [%s, %s, score %.2f]\n", i+1, filepath.Base(result.Path), result.Type, period, result.Score)
+
+		text := strings.NewReplacer("\x00", open, "\x01", close).Replace(result.Snippet)
+		fmt.Fprintf(w, "   %s\n", text)
+	}
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSnippet(t *testing.T) {
+	tests := []struct {
+		text  string
+		terms []searchTerm
+		want  string
+	}{
+		{"Verdienstabrechnung März", []searchTerm{{text: "märz"}}, "Verdienstabrechnung \x00März\x01"},
+		// Prefixes only match at the start of a word
+		{"Verdienstabrechnung, Abrechnungsmonat März", []searchTerm{{text: "abrech", prefix: true}}, "Verdienstabrechnung, \x00Abrechnungsmonat\x01 März"},
+		// Whole terms don't match parts of words
+		{"Dienstwagen Dienst", []searchTerm{{text: "dienst"}}, "Dienstwagen \x00Dienst\x01"},
+		{"Gesamtbrutto 5.000,00", []searchTerm{{text: "000"}}, "Gesamtbrutto 5.\x00000\x01,00"},
+	}
+	for _, tt := range tests {
+		if got := snippet(tt.text, tt.terms); got != tt.want {
+			t.Errorf("snippet(%q, %v) = %q, want %q", tt.text, tt.terms, got, tt.want)
+		}
+	}
+}
+
+func TestUpdateSearchIndexKeepsCopy(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := OpenCache(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := OpenIndex(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer index.Close()
+
+	// Two copies of the same payslip, classified through the cache
+	var hash string
+	for _, name := range []string{"a.pdf", "b.pdf"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, testPDF(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if hash, err = fileSHA256(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc := Document{Path: filepath.Join(dir, "a.pdf"), Hash: hash, Type: DocumentTypePayslip, Period: Period{Year: 2024, Month: time.May}}
+	if err := cache.Store(doc, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	var paths []string
+	for run := 0; run < 2; run++ {
+		if err := index.UpdateSearchIndex([]string{dir}, defaultWalkOptions, readOptions{Cache: cache}); err != nil {
+			t.Fatalf("UpdateSearchIndex: %v", err)
+		}
+		var path string
+		if err := index.db.QueryRow(`SELECT path FROM search_documents WHERE hash = ?`, hash).Scan(&path); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	// The copy indexed first stays in the index
+	first := filepath.Join(dir, "a.pdf")
+	if paths[0] != first || paths[1] != first {
+		t.Errorf("indexed paths %v, want %s on every run", paths, first)
+	}
+
+	// A moved copy is found again
+	if err := os.Rename(paths[1], filepath.Join(dir, "c.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(dir, "b.pdf")); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.UpdateSearchIndex([]string{dir}, defaultWalkOptions, readOptions{Cache: cache}); err != nil {
+		t.Fatalf("UpdateSearchIndex: %v", err)
+	}
+	var path string
+	if err := index.db.QueryRow(`SELECT path FROM search_documents WHERE hash = ?`, hash).Scan(&path); err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, "c.pdf") {
+		t.Errorf("moved document indexed at %s, want c.pdf", path)
+	}
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// SelectorProfile locates the elements of the portal the downloader interacts
+// with. Each element lists CSS selectors that are tried in order.
+type SelectorProfile struct {
+	// Version identifies the portal markup the profile was written for
+	Version string `yaml:"version"`
+
+	// Login form custom elements, whose input may be inside a shadow root
+	Username       []string `yaml:"username,omitempty"`
+	UsernameSubmit []string `yaml:"username_submit,omitempty"`
+	Password       []string `yaml:"password,omitempty"`
+	PasswordSubmit []string `yaml:"password_submit,omitempty"`
+
+	// AllDocuments are Go regular expressions matching the text of the dashboard
+	// button leading to the document list. They are tried before the locales' texts.
+	AllDocuments []string `yaml:"all_documents,omitempty"`
+	// DocumentTable is the table listing the documents of the current page
+	DocumentTable []string `yaml:"document_table,omitempty"`
+	// DocumentLink matches the download links within the page
+	DocumentLink []string `yaml:"document_link,omitempty"`
+	// NextPage is the document list's next page link. It is tried before the
+	// locale's aria-label.
+	NextPage []string `yaml:"next_page,omitempty"`
+}
+
+// builtinSelectorProfiles lists the known-good variants of the portal markup,
+// newest first
+var builtinSelectorProfiles = []SelectorProfile{
+	{
+		Version:        "world-v2",
+		Username:       []string{"#login-form_username"},
+		UsernameSubmit: []string{"#verifUseridBtn"},
+		Password:       []string{"#login-form_password"},
+		PasswordSubmit: []string{"#signBtn"},
+		DocumentTable:  []string{`#epaysliplist\:ePayListForm\:ePayslipDocs > div.ui-datatable-tablewrapper > table`},
+		DocumentLink:   []string{`a[href*="/AdpwAdpaWeb/DocDownload"]`},
+	},
+	{
+		// Attributes that survive most changes of ids and wrappers
+		Version:        "generic",
+		Username:       []string{`[id$="_username"]`, `input[autocomplete="username"]`, `input[name="username"]`},
+		UsernameSubmit: []string{`[id^="verifUserid"]`, `button[type="submit"]`},
+		Password:       []string{`[id$="_password"]`, `input[type="password"]`},
+		PasswordSubmit: []string{`[id^="signBtn"]`, `button[type="submit"]`},
+		DocumentTable:  []string{`[id$="ePayslipDocs"] table`, `.ui-datatable table`},
+		DocumentLink:   []string{`a[href*="DocDownload"]`},
+	},
+}
+
+// readSelectorProfile reads a profile overriding the built-in selectors. A
+// missing file is not an error and yields nil.
+func readSelectorProfile(path string) (*SelectorProfile, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Reject unknown keys so that typos don't silently fall back to the built-in selectors
+	var profile SelectorProfile
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&profile); err != nil {
+		return nil, fmt.Errorf("failed to decode selector profile %s: %v", path, err)
+	}
+	if profile.Version == "" {
+		return nil, fmt.Errorf("selector profile %s has no version", path)
+	}
+	for _, pattern := range profile.AllDocuments {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return nil, fmt.Errorf("invalid all_documents pattern %q in %s: %v", pattern, path, err)
+		}
+	}
+	return &profile, nil
+}
+
+// selectorChain combines the override, if any, and the built-in profiles into
+// one profile whose selectors are tried in that order
+func selectorChain(override *SelectorProfile) SelectorProfile {
+	profiles := builtinSelectorProfiles
+	if override != nil {
+		profiles = append([]SelectorProfile{*override}, profiles...)
+	}
+
+	var chain SelectorProfile
+	var versions []string
+	for _, p := range profiles {
+		versions = append(versions, p.Version)
+		chain.Username = appendUnique(chain.Username, p.Username...)
+		chain.UsernameSubmit = appendUnique(chain.UsernameSubmit, p.UsernameSubmit...)
+		chain.Password = appendUnique(chain.Password, p.Password...)
+		chain.PasswordSubmit = appendUnique(chain.PasswordSubmit, p.PasswordSubmit...)
+		chain.AllDocuments = appendUnique(chain.AllDocuments, p.AllDocuments...)
+		chain.DocumentTable = appendUnique(chain.DocumentTable, p.DocumentTable...)
+		chain.DocumentLink = appendUnique(chain.DocumentLink, p.DocumentLink...)
+		chain.NextPage = appendUnique(chain.NextPage, p.NextPage...)
+	}
+	chain.Version = strings.Join(versions, " > ")
+	return chain
+}
+
+// appendUnique appends the values not yet in list
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
@@ -0,0 +1,152 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sidecarFormats lists the supported sidecar file formats
+var sidecarFormats = []string{"json", "yaml"}
+
+// Sidecar is the metadata written next to a processed PDF
+type Sidecar struct {
+	Type             DocumentType      `json:"type" yaml:"type"`
+	Period           string            `json:"period" yaml:"period"`
+	CorrectedPeriod  string            `json:"corrected_period,omitempty" yaml:"corrected_period,omitempty"`
+	CorrectionNumber int               `json:"correction_number,omitempty" yaml:"correction_number,omitempty"`
+	Supersedes       string            `json:"supersedes,omitempty" yaml:"supersedes,omitempty"`
+	SourceLink       string            `json:"source_link,omitempty" yaml:"source_link,omitempty"`
+	SourceLinkID     string            `json:"source_link_id,omitempty" yaml:"source_link_id,omitempty"`
+	Hash             string            `json:"hash" yaml:"hash"`
+	OriginalFilename string            `json:"original_filename" yaml:"original_filename"`
+	Fields           map[string]string `json:"fields,omitempty" yaml:"fields,omitempty"`
+	ProcessedAt      string            `json:"processed_at" yaml:"processed_at"`
+	// RulesVersion is the rulesVersion the classification was made with
+	RulesVersion int `json:"rules_version" yaml:"rules_version"`
+}
+
+func isSidecarFormat(format string) bool {
+	for _, f := range sidecarFormats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// sidecarPath returns the sidecar location for a PDF, e.g. "x.json" for "x.pdf"
+func sidecarPath(pdfPath, format string) string {
+	return strings.TrimSuffix(pdfPath, filepath.Ext(pdfPath)) + "." + format
+}
+
+// newSidecar collects the metadata of a classified document
+func newSidecar(doc Document, originalFilename string, fields map[string]string, entry *IndexEntry) Sidecar {
+	sidecar := Sidecar{
+		Type:             doc.Type,
+		Period:           doc.Period.Key(),
+		CorrectionNumber: doc.CorrectionNumber,
+		Hash:             doc.Hash,
+		OriginalFilename: originalFilename,
+		Fields:           fields,
+		ProcessedAt:      time.Now().UTC().Format(time.RFC3339),
+		RulesVersion:     rulesVersion,
+	}
+	if doc.Correction != nil {
+		sidecar.CorrectedPeriod = doc.Correction.Key()
+	}
+	if doc.Supersedes != "" {
+		sidecar.Supersedes = filepath.Base(doc.Supersedes)
+	}
+	if entry != nil {
+		sidecar.SourceLink = entry.Link
+		sidecar.SourceLinkID = entry.LinkID
+		if entry.OriginalName != "" {
+			sidecar.OriginalFilename = entry.OriginalName
+		}
+	}
+	return sidecar
+}
+
+// writeSidecar writes the metadata in the given format
+func writeSidecar(path, format string, sidecar Sidecar) error {
+	var data []byte
+	var err error
+	switch format {
+	case "json":
+		data, err = json.MarshalIndent(sidecar, "", "  ")
+		data = append(data, '\n')
+	case "yaml":
+		data, err = yaml.Marshal(sidecar)
+	default:
+		return fmt.Errorf("unsupported sidecar format: %s", format)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to encode sidecar: %v", err)
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// readSidecar reads the sidecar of a PDF in any supported format
+func readSidecar(pdfPath string) (Sidecar, string, bool) {
+	for _, format := range sidecarFormats {
+		path := sidecarPath(pdfPath, format)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+
+		var sidecar Sidecar
+		if format == "json" {
+			err = json.Unmarshal(data, &sidecar)
+		} else {
+			err = yaml.Unmarshal(data, &sidecar)
+		}
+		if err != nil {
+			continue
+		}
+		return sidecar, path, true
+	}
+	return Sidecar{}, "", false
+}
+
+// Document rebuilds the classification recorded in the sidecar
+func (s Sidecar) Document(pdfPath string) (Document, error) {
+	return newDocument(pdfPath, s.Hash, s.Type, s.Period, s.CorrectedPeriod)
+}
+
+// newDocument rebuilds a classification from periods formatted by Period.Key
+func newDocument(path, hash string, docType DocumentType, period, correctedPeriod string) (Document, error) {
+	doc := Document{Path: path, Type: docType, Hash: hash}
+
+	p, err := parsePeriodKey(period)
+	if err != nil {
+		return doc, err
+	}
+	doc.Period = p
+
+	if correctedPeriod != "" {
+		corrected, err := parsePeriodKey(correctedPeriod)
+		if err != nil {
+			return doc, err
+		}
+		doc.Correction = &corrected
+	}
+	return doc, nil
+}
+
+// parsePeriodKey parses a period formatted by Period.Key
+func parsePeriodKey(key string) (Period, error) {
+	if t, err := time.Parse("2006-01", key); err == nil {
+		return Period{Year: t.Year(), Month: t.Month()}, nil
+	}
+	if t, err := time.Parse("2006", key); err == nil {
+		return Period{Year: t.Year()}, nil
+	}
+	return Period{}, fmt.Errorf("invalid period %q", key)
+}
@@ -0,0 +1,89 @@
+package cmd
+
+import (
+	"fmt"
+)
+
+// TaxCertificate holds the amounts parsed from a Lohnsteuerbescheinigung
+type TaxCertificate struct {
+	Document
+
+	// Nr. 3: Bruttoarbeitslohn
+	GrossWage Money
+	// Nr. 4: Einbehaltene Lohnsteuer
+	IncomeTax Money
+	// Nr. 5: Einbehaltener Solidaritätszuschlag
+	SolidaritySurcharge Money
+	// Nr. 6: Einbehaltene Kirchensteuer des Arbeitnehmers
+	ChurchTax Money
+	// Nr. 22a: Arbeitgeberanteil zur gesetzlichen Rentenversicherung
+	EmployerPensionInsurance Money
+	// Nr. 23a: Arbeitnehmeranteil zur gesetzlichen Rentenversicherung
+	PensionInsurance Money
+	// Nr. 25: Arbeitnehmerbeiträge zur gesetzlichen Krankenversicherung
+	HealthInsurance Money
+	// Nr. 26: Arbeitnehmerbeiträge zur sozialen Pflegeversicherung
+	CareInsurance Money
+	// Nr. 27: Arbeitnehmerbeiträge zur Arbeitslosenversicherung
+	UnemploymentInsurance Money
+
+	// Found holds the names of the fields found on the certificate
+	Found map[string]bool
+}
+
+// taxCertificateField describes how to find one numbered line on a tax certificate
+type taxCertificateField struct {
+	line string
+	// name looks up the label in the locale's TaxCertificateLabels
+	name  string
+	field func(c *TaxCertificate) *Money
+	// extractor selects the text the label is searched in
+	extractor string
+}
+
+// taxCertificateFields lists the lines parsed from tax certificates
+var taxCertificateFields = []taxCertificateField{
+	{"3", "gross_wage", func(c *TaxCertificate) *Money { return &c.GrossWage }, extractorPlain},
+	{"4", "income_tax", func(c *TaxCertificate) *Money { return &c.IncomeTax }, extractorPlain},
+	{"5", "solidarity_surcharge", func(c *TaxCertificate) *Money { return &c.SolidaritySurcharge }, extractorPlain},
+	{"6", "church_tax", func(c *TaxCertificate) *Money { return &c.ChurchTax }, extractorPlain},
+	{"22a", "employer_pension_insurance", func(c *TaxCertificate) *Money { return &c.EmployerPensionInsurance }, extractorPlain},
+	{"23a", "pension_insurance", func(c *TaxCertificate) *Money { return &c.PensionInsurance }, extractorPlain},
+	{"25", "health_insurance", func(c *TaxCertificate) *Money { return &c.HealthInsurance }, extractorPlain},
+	{"26", "care_insurance", func(c *TaxCertificate) *Money { return &c.CareInsurance }, extractorPlain},
+	{"27", "unemployment_insurance", func(c *TaxCertificate) *Money { return &c.UnemploymentInsurance }, extractorPlain},
+}
+
+// parseTaxCertificate extracts the numbered lines from a classified tax
+// certificate using the labels and number format of its locale
+func parseTaxCertificate(doc Document) (TaxCertificate, error) {
+	if doc.Type != DocumentTypeTaxCertificate {
+		return TaxCertificate{}, fmt.Errorf("not a tax certificate: %s", doc.Type)
+	}
+
+	certificate := TaxCertificate{Document: doc, Found: make(map[string]bool)}
+	locale := documentLocale(doc)
+	layout := lazyLayout(doc)
+	lines := documentLines(doc, layout)
+	for _, f := range taxCertificateFields {
+		if amount, ok := findRuleAmount(lines, layout, f.extractor, locale.TaxCertificateLabels[f.name], locale); ok {
+			*f.field(&certificate) = amount
+			certificate.Found[f.name] = true
+		}
+	}
+
+	if certificate.GrossWage == 0 {
+		return certificate, fmt.Errorf("couldn't find Bruttoarbeitslohn in %s", doc.Path)
+	}
+
+	return certificate, nil
+}
+
+// Fields returns the parsed amounts keyed by field name
+func (c TaxCertificate) Fields() map[string]Money {
+	fields := make(map[string]Money, len(taxCertificateFields))
+	for _, f := range taxCertificateFields {
+		fields[f.name] = *f.field(&c)
+	}
+	return fields
+}
@@ -0,0 +1,215 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// NewUndoCmd creates and configures the undo command
+func NewUndoCmd(config Config) *cobra.Command {
+	var (
+		journalDir string
+		indexPath  string
+		list       bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "undo [run-id]",
+		Short: "Revert the renames of a process run",
+		Long: `Revert the renames of a process run, the latest one not yet undone by default.
+Refuses if any renamed file has been moved or changed since the run, or if its
+original name is taken. Originals replaced by --store-decrypted replace are
+restored from their backup; metadata embedded into other files with
+--embed-metadata is not removed.`,
+		Args: cobra.MaximumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			journals, err := listJournals(journalDir)
+			if err != nil {
+				log.Error("Error reading journals", "error", err)
+				os.Exit(1)
+			}
+
+			if list {
+				for _, journal := range journals {
+					log.Info("Run",
+						"run_id", journal.RunID,
+						"path", journal.Paths,
+						"renames", len(journal.Renames),
+						"undone_at", journal.UndoneAt)
+				}
+				return
+			}
+
+			var journal *Journal
+			if len(args) == 1 {
+				if journal, err = readJournal(journalDir, args[0]); err != nil {
+					log.Error("Error reading journal", "error", err)
+					os.Exit(1)
+				}
+				if journal.UndoneAt != "" {
+					log.Error("Run has already been undone", "run_id", journal.RunID, "undone_at", journal.UndoneAt)
+					os.Exit(1)
+				}
+			} else {
+				for i := len(journals) - 1; i >= 0; i-- {
+					if journals[i].UndoneAt == "" {
+						journal = journals[i]
+						break
+					}
+				}
+				if journal == nil {
+					log.Error("No run to undo", "journal", journalDir)
+					os.Exit(1)
+				}
+			}
+
+			var index *Index
+			if indexPath != "" {
+				if index, err = OpenIndex(indexPath); err != nil {
+					log.Error("Error opening index", "error", err)
+					os.Exit(1)
+				}
+				defer index.Close()
+			}
+
+			if err := undoRun(journal, journalDir, index); err != nil {
+				log.Error("Error undoing run", "error", err)
+				os.Exit(1)
+			}
+
+			journal.UndoneAt = time.Now().UTC().Format(time.RFC3339)
+			if err := journal.Save(journalDir); err != nil {
+				log.Error("Error saving journal", "error", err)
+				os.Exit(1)
+			}
+
+			log.Info("Undid run", "run_id", journal.RunID, "renames", len(journal.Renames))
+		},
+	}
+
+	cmd.Flags().StringVar(&journalDir, "journal", filepath.Join(config.DataDir, "journal"), "Directory containing the journals of process runs")
+	cmd.Flags().StringVar(&indexPath, "index", filepath.Join(config.DataDir, "index.db"), "Path to the document index (empty to disable)")
+	cmd.Flags().BoolVar(&list, "list", false, "List the recorded runs")
+
+	return cmd
+}
+
+// undoRun moves the files of a run back to their original names, last rename first.
+// Each restored file is recorded in the journal right away, so that undoing
+// again after a failure midway continues with the remaining files.
+func undoRun(journal *Journal, journalDir string, index *Index) error {
+	if err := journal.checkUndo(); err != nil {
+		return err
+	}
+
+	for i := len(journal.Renames) - 1; i >= 0; i-- {
+		entry := journal.Renames[i]
+		if entry.Restored {
+			continue
+		}
+		// Entries without a rename only record a replaced original
+		if entry.To != entry.From {
+			if err := os.Rename(entry.To, entry.From); err != nil {
+				return err
+			}
+		}
+		journal.Renames[i].Restored = true
+		if err := journal.Save(journalDir); err != nil {
+			return fmt.Errorf("restored %s but failed to record it in the journal: %v", entry.From, err)
+		}
+		if entry.To != entry.From {
+			log.Info("Restored file", "old", filepath.Base(entry.To), "new", filepath.Base(entry.From))
+		}
+
+		// Put back the encrypted original replaced by its decrypted content
+		hash := entry.Hash
+		restored := false
+		if entry.Backup != "" {
+			if err := restoreBackup(entry.Backup, entry.From); err != nil {
+				log.Warn("Failed to restore encrypted original", "filename", filepath.Base(entry.From), "backup", entry.Backup, "error", err)
+			} else {
+				log.Info("Restored encrypted original", "filename", filepath.Base(entry.From))
+				restored = true
+				hash = entry.OriginalHash
+				if index != nil {
+					if err := index.UpdateHash(entry.Hash, hash); err != nil {
+						log.Warn("Failed to update index", "filename", filepath.Base(entry.From), "error", err)
+					}
+				}
+			}
+		}
+		if kept := keptChanges(entry, restored); kept != "" {
+			log.Warn(kept, "filename", filepath.Base(entry.From))
+		}
+
+		// Remove the decrypted copy written by the run
+		if entry.Decrypted != "" {
+			if err := os.Remove(entry.Decrypted); err != nil && !os.IsNotExist(err) {
+				log.Warn("Failed to remove decrypted copy", "filename", filepath.Base(entry.Decrypted), "error", err)
+			}
+		}
+
+		// Move the sidecar along so that it stays next to its PDF
+		if entry.Sidecar != "" {
+			format := strings.TrimPrefix(filepath.Ext(entry.Sidecar), ".")
+			if err := os.Rename(entry.Sidecar, sidecarPath(entry.From, format)); err != nil && !os.IsNotExist(err) {
+				log.Warn("Failed to move sidecar", "filename", filepath.Base(entry.Sidecar), "error", err)
+			}
+		}
+
+		if index != nil {
+			if err := index.UpdatePath(hash, entry.From); err != nil {
+				log.Warn("Failed to update index", "filename", filepath.Base(entry.From), "error", err)
+			}
+		}
+	}
+	return nil
+}
+
+// keptChanges describes the changes a run made to a file's content that undo
+// keeps, or returns "" if there are none. A restored backup predates all of them.
+func keptChanges(entry JournalEntry, restored bool) string {
+	switch {
+	case restored:
+		return ""
+	case entry.EmbeddedMetadata && entry.Backup != "":
+		return "Embedded metadata and decryption are kept"
+	case entry.EmbeddedMetadata:
+		return "Embedded metadata is kept"
+	case entry.Backup != "":
+		return "Decryption is kept"
+	case entry.Hash != entry.OriginalHash:
+		// Journals of earlier versions don't record what changed
+		return "Changes to the content are kept"
+	}
+	return ""
+}
+
+// restoreBackup moves a backup over path, keeping path's mode. The backup is
+// copied first, as it usually lives on another file system.
+func restoreBackup(backup, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := copyFile(backup, tmpPath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Remove(backup)
+}
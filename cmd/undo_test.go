@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testJournal renames files named after contents and returns the journal of the renames
+func testJournal(t *testing.T, dir string, contents ...string) *Journal {
+	t.Helper()
+	journal := newJournal([]string{dir})
+	for _, content := range contents {
+		from := filepath.Join(dir, content+".pdf")
+		to := filepath.Join(dir, "renamed-"+content+".pdf")
+		if err := os.WriteFile(to, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hash, err := fileSHA256(to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		journal.Renames = append(journal.Renames, JournalEntry{From: from, To: to, OriginalHash: hash, Hash: hash})
+	}
+	return journal
+}
+
+func TestUndoRunRecordsProgress(t *testing.T) {
+	dir := t.TempDir()
+	journalDir := filepath.Join(dir, "journal")
+	journal := testJournal(t, dir, "a", "b", "c")
+
+	if err := undoRun(journal, journalDir, nil); err != nil {
+		t.Fatalf("undoRun: %v", err)
+	}
+
+	saved, err := readJournal(journalDir, journal.RunID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range saved.Renames {
+		if !entry.Restored {
+			t.Errorf("%s not recorded as restored", entry.From)
+		}
+		if _, err := os.Stat(entry.From); err != nil {
+			t.Errorf("%s not restored: %v", entry.From, err)
+		}
+	}
+}
+
+func TestUndoRunContinuesAfterInterruption(t *testing.T) {
+	dir := t.TempDir()
+	journalDir := filepath.Join(dir, "journal")
+	journal := testJournal(t, dir, "a", "b")
+
+	// An earlier undo restored the last rename before failing
+	last := journal.Renames[1]
+	if err := os.Rename(last.To, last.From); err != nil {
+		t.Fatal(err)
+	}
+	journal.Renames[1].Restored = true
+
+	if err := undoRun(journal, journalDir, nil); err != nil {
+		t.Fatalf("undoRun after interruption: %v", err)
+	}
+	for _, entry := range journal.Renames {
+		if _, err := os.Stat(entry.From); err != nil {
+			t.Errorf("%s not restored: %v", entry.From, err)
+		}
+	}
+}
+
+func TestKeptChanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		entry    JournalEntry
+		restored bool
+		want     string
+	}{
+		{"renamed only", JournalEntry{OriginalHash: "a", Hash: "a"}, false, ""},
+		{"embedded metadata", JournalEntry{OriginalHash: "a", Hash: "b", EmbeddedMetadata: true}, false, "Embedded metadata is kept"},
+		{"decrypted", JournalEntry{OriginalHash: "a", Hash: "b", Backup: "a.pdf"}, false, "Decryption is kept"},
+		{"both", JournalEntry{OriginalHash: "a", Hash: "c", Backup: "a.pdf", EmbeddedMetadata: true}, false, "Embedded metadata and decryption are kept"},
+		{"backup restored", JournalEntry{OriginalHash: "a", Hash: "c", Backup: "a.pdf", EmbeddedMetadata: true}, true, ""},
+		{"earlier journal", JournalEntry{OriginalHash: "a", Hash: "b"}, false, "Changes to the content are kept"},
+	}
+	for _, tt := range tests {
+		if got := keptChanges(tt.entry, tt.restored); got != tt.want {
+			t.Errorf("%s: keptChanges = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
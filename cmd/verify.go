@@ -0,0 +1,228 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/charmbracelet/log"
+	"github.com/spf13/cobra"
+)
+
+// reconciledLines maps tax certificate lines to the payslip amounts summed for them
+var reconciledLines = []struct {
+	description      string
+	certificateField string
+	payslipField     string
+}{
+	{"Bruttoarbeitslohn", "gross_wage", "taxable_gross"},
+	{"Lohnsteuer", "income_tax", "income_tax"},
+	{"Solidaritätszuschlag", "solidarity_surcharge", "solidarity_surcharge"},
+	{"Kirchensteuer", "church_tax", "church_tax"},
+	{"Rentenversicherung (AN)", "pension_insurance", "pension_insurance"},
+	{"Krankenversicherung (AN)", "health_insurance", "health_insurance"},
+	{"Pflegeversicherung (AN)", "care_insurance", "care_insurance"},
+	{"Arbeitslosenversicherung (AN)", "unemployment_insurance", "unemployment_insurance"},
+}
+
+// NewVerifyCmd creates and configures the verify command
+func NewVerifyCmd(config Config) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Cross-check archived documents",
+		Long:  `Check the documents in the archive for consistency.`,
+	}
+
+	cmd.AddCommand(NewVerifyReconcileCmd(config))
+	cmd.AddCommand(NewVerifyCompletenessCmd(config))
+
+	return cmd
+}
+
+// NewVerifyReconcileCmd creates and configures the verify reconcile command
+func NewVerifyReconcileCmd(config Config) *cobra.Command {
+	var (
+		pdfPaths  []string
+		walk      walkOptions
+		format    string
+		tolerance float64
+		read      readFlags
+	)
+
+	cmd := &cobra.Command{
+		Use:   "reconcile [YYYY...]",
+		Short: "Reconcile payslips with the Lohnsteuerbescheinigung",
+		Long: `Sum the monthly payslips of a year, using Rückrechnungen in place of the months
+they correct, and compare the totals with Nr. 3 to 6 and the social insurance
+lines of the year's Lohnsteuerbescheinigung. Nr. 3 is compared with the
+Steuer-Brutto of the payslips. Lines missing from the Lohnsteuerbescheinigung
+are reported as not found. Without arguments all years with a
+Lohnsteuerbescheinigung are checked. Exits with status 1 if any difference
+exceeds the tolerance.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !isReportFormat(format) {
+				log.Error("Unsupported report format", "format", format, "supported", reportFormats)
+				os.Exit(1)
+			}
+
+			if err := checkWalkFlags(pdfPaths, walk); err != nil {
+				log.Error("Invalid PDF selection", "error", err)
+				os.Exit(1)
+			}
+
+			opts, err := read.readOptions(cmd, false)
+			if err != nil {
+				log.Error("Error setting up text extraction", "error", err)
+				os.Exit(1)
+			}
+
+			docs, err := loadDocuments(pdfPaths, walk, opts)
+			if err != nil {
+				log.Error("Error loading documents", "error", err)
+				os.Exit(1)
+			}
+
+			var years []int
+			for _, arg := range args {
+				year, err := strconv.Atoi(arg)
+				if err != nil || len(arg) != 4 {
+					log.Error("Invalid year", "year", arg)
+					os.Exit(1)
+				}
+				years = append(years, year)
+			}
+			if len(years) == 0 {
+				years = taxCertificateYears(docs)
+			}
+			if len(years) == 0 {
+				log.Error("No Lohnsteuerbescheinigung found", "path", pdfPaths)
+				os.Exit(1)
+			}
+
+			maxDifference := Money(math.Round(tolerance * 100))
+			ok, err := reconcile(os.Stdout, format, years, docs, maxDifference)
+			if err != nil {
+				log.Error("Error reconciling documents", "error", err)
+				os.Exit(1)
+			}
+			if !ok {
+				log.Error("Payslips and Lohnsteuerbescheinigung disagree", "tolerance", maxDifference)
+				os.Exit(1)
+			}
+
+			log.Info("Payslips and Lohnsteuerbescheinigung agree", "years", years)
+		},
+	}
+
+	addWalkFlags(cmd, config, &pdfPaths, &walk)
+	cmd.Flags().StringVar(&format, "format", "table", "Output format (table, markdown, html)")
+	cmd.Flags().Float64Var(&tolerance, "tolerance", 1.00, "Maximum accepted difference in euros")
+	addReadFlags(cmd, config, &read)
+
+	return cmd
+}
+
+// taxCertificateYears returns the years with a Lohnsteuerbescheinigung in ascending order
+func taxCertificateYears(docs []Document) []int {
+	var years []int
+	seen := make(map[int]bool)
+	for _, doc := range docs {
+		if doc.Type == DocumentTypeTaxCertificate && !seen[doc.Period.Year] {
+			seen[doc.Period.Year] = true
+			years = append(years, doc.Period.Year)
+		}
+	}
+	return years
+}
+
+// reconcile compares the payslips of each year with its tax certificate and
+// writes one report per year. It reports whether all differences are within tolerance.
+func reconcile(w io.Writer, format string, years []int, docs []Document, tolerance Money) (bool, error) {
+	allOK := true
+	for _, year := range years {
+		report, ok, err := reconcileYear(year, docs, tolerance)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			allOK = false
+		}
+		if err := renderReport(w, format, report); err != nil {
+			return false, err
+		}
+	}
+	return allOK, nil
+}
+
+func reconcileYear(year int, docs []Document, tolerance Money) (reportDoc, bool, error) {
+	report := reportDoc{Title: fmt.Sprintf("Abgleich %d", year)}
+
+	certificate, err := findTaxCertificate(year, docs)
+	if err != nil {
+		return report, false, err
+	}
+	if certificate == nil {
+		report.Notes = append(report.Notes, fmt.Sprintf("No Lohnsteuerbescheinigung for %d found.", year))
+		return report, false, nil
+	}
+
+	payslips := payslipsForYear(year, docs)
+	var total Payslip
+	for _, payslip := range payslips {
+		total = total.plus(payslip)
+	}
+	if len(payslips) < 12 {
+		report.Notes = append(report.Notes, fmt.Sprintf(
+			"Only %d of 12 monthly payslips found for %d.", len(payslips), year))
+	}
+
+	lines := make(map[string]taxCertificateField)
+	for _, f := range taxCertificateFields {
+		lines[f.name] = f
+	}
+
+	table := reportTable{
+		Title:  "Payslips vs. Lohnsteuerbescheinigung",
+		Header: []string{"Line", "Nr.", "Payslips", "Certificate", "Difference", "Status"},
+	}
+	payslipAmounts := total.Fields()
+	certificateAmounts := certificate.Fields()
+	ok := true
+	for _, line := range reconciledLines {
+		summed := payslipAmounts[line.payslipField]
+		// Lines missing from the certificate aren't zero, so they don't count
+		if !certificate.Found[line.certificateField] {
+			table.Rows = append(table.Rows, []string{
+				line.description,
+				lines[line.certificateField].line,
+				summed.German(),
+				"not found",
+				"",
+				"NOT FOUND",
+			})
+			continue
+		}
+		certified := certificateAmounts[line.certificateField]
+		difference := summed - certified
+
+		status := "OK"
+		if difference > tolerance || -difference > tolerance {
+			status = "MISMATCH"
+			ok = false
+		}
+
+		table.Rows = append(table.Rows, []string{
+			line.description,
+			lines[line.certificateField].line,
+			summed.German(),
+			certified.German(),
+			difference.German(),
+			status,
+		})
+	}
+	report.Tables = append(report.Tables, table)
+
+	return report, ok, nil
+}
@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestReconcileYear(t *testing.T) {
+	// Nr. 3 excludes the tax-free part of the Gesamtbrutto; Nr. 23a to 27 are missing
+	certificate, err := classifyDocument("certificate.pdf", "Ausdruck der elektronischen Lohnsteuerbescheinigung für 2023\n"+
+		"3. Bruttoarbeitslohn 58.800,00\n4. Einbehaltene Lohnsteuer 12.000,00\n"+
+		"5. Einbehaltener Solidaritätszuschlag 0,00\n6. Einbehaltene Kirchensteuer des Arbeitnehmers 0,00\n", nil)
+	if err != nil {
+		t.Fatalf("classifyDocument: %v", err)
+	}
+	docs := []Document{certificate}
+	for _, month := range germanLocale.MonthNames {
+		doc, err := classifyDocument(month+".pdf", fmt.Sprintf("Verdienstabrechnung\nAbrechnungsmonat: %s 2023\n"+
+			"Gesamtbrutto 5.000,00\nSteuer-Brutto 4.900,00\nLohnsteuer 1.000,00\nNettoverdienst 3.900,00\n", month), nil)
+		if err != nil {
+			t.Fatalf("classifyDocument(%s): %v", month, err)
+		}
+		docs = append(docs, doc)
+	}
+
+	report, ok, err := reconcileYear(2023, docs, 100)
+	if err != nil {
+		t.Fatalf("reconcileYear: %v", err)
+	}
+	if !ok {
+		t.Errorf("reconcileYear = not ok, want ok: %v", report.Tables[0].Rows)
+	}
+	for _, row := range report.Tables[0].Rows {
+		switch row[1] {
+		case "3":
+			if row[2] != "58.800,00" || row[5] != "OK" {
+				t.Errorf("Nr. 3 = %v, want the Steuer-Brutto 58.800,00 and OK", row)
+			}
+		case "23a", "25", "26", "27":
+			if row[3] != "not found" || row[5] != "NOT FOUND" {
+				t.Errorf("Nr. %s = %v, want not found", row[1], row)
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// Waits react to DOM mutations and network events instead of polling. Some
+// changes, e.g. an element becoming visible once a stylesheet loads, cause no
+// mutation, so the DOM is checked again after domChangeFallback at the latest.
+const (
+	// domChangeFallback is the longest time a DOM check is not repeated
+	domChangeFallback = time.Second
+	// domRetryDelay is the pause before evaluating again after a navigation
+	// replaced the document
+	domRetryDelay = 100 * time.Millisecond
+	// networkQuiet is how long no request may be pending for the network to count as idle
+	networkQuiet = 500 * time.Millisecond
+)
+
+// domChangesScript counts the DOM mutations of the current document in
+// window.__adpChanges, installing a MutationObserver on first use. Waiters
+// registered in listeners are called on the next mutation.
+const domChangesScript = `
+	if (!window.__adpChanges) {
+		window.__adpChanges = {count: 0, listeners: []};
+		new MutationObserver(function() {
+			const changes = window.__adpChanges;
+			changes.count++;
+			const listeners = changes.listeners;
+			changes.listeners = [];
+			listeners.forEach(function(listener) { listener(); });
+		}).observe(document, {subtree: true, childList: true, attributes: true, characterData: true});
+	}
+`
+
+// visibleElementScript is a function returning the index of the first of a list
+// of selectors matching a visible element, or -1
+const visibleElementScript = `
+	(function(selectors) {
+		for (let i = 0; i < selectors.length; i++) {
+			let el = null;
+			try {
+				el = document.querySelector(selectors[i]);
+			} catch (e) {
+				continue;
+			}
+			if (el !== null &&
+				(el.offsetWidth > 0 || el.offsetHeight > 0 || el.getClientRects().length > 0)) {
+				return i;
+			}
+		}
+		return -1;
+	})
+`
+
+// waitForAnyElement waits for one of several selectors to match a visible element
+// and returns the first one that does
+func waitForAnyElement(ctx context.Context, selectors []string, timeout time.Duration) (string, error) {
+	log.Debug("Waiting for any element", "selectors", selectors, "timeout", timeout)
+	if len(selectors) == 0 {
+		return "", fmt.Errorf("no selectors given")
+	}
+	selectorsJSON, err := json.Marshal(selectors)
+	if err != nil {
+		return "", err
+	}
+
+	// Find the first selector matching a visible element
+	var found int
+	err = waitForDOM(ctx, timeout, "any element of: "+strings.Join(selectors, " | "), visibleElementScript+`(`+string(selectorsJSON)+`)`, func(value json.RawMessage) (bool, error) {
+		if err := json.Unmarshal(value, &found); err != nil {
+			return false, err
+		}
+		return found >= 0, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return selectors[found], nil
+}
+
+// waitForText waits for text matching a regex pattern to appear on the page
+func waitForText(ctx context.Context, pattern string, timeout time.Duration) error {
+	log.Debug("Waiting for text matching pattern", "pattern", pattern, "timeout", timeout)
+
+	// Compile the regex
+	regex, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid regex pattern: %v", err)
+	}
+
+	return waitForDOM(ctx, timeout, "text matching: "+pattern, `document.body ? document.body.innerText : ""`, func(value json.RawMessage) (bool, error) {
+		var pageText string
+		if err := json.Unmarshal(value, &pageText); err != nil {
+			return false, err
+		}
+		return regex.MatchString(pageText), nil
+	})
+}
+
+// waitForDOM evaluates expr whenever the DOM changes until satisfied accepts its
+// JSON value. what describes the awaited state in the timeout error.
+func waitForDOM(ctx context.Context, timeout time.Duration, what, expr string, satisfied func(value json.RawMessage) (bool, error)) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	for {
+		// Read the state together with the number of changes it reflects, so
+		// that no change between the check and the wait is missed
+		var state struct {
+			Changes int             `json:"changes"`
+			Value   json.RawMessage `json:"value"`
+		}
+		err := chromedp.Run(timeoutCtx, chromedp.Evaluate(`
+			(function() {
+				`+domChangesScript+`
+				return {changes: window.__adpChanges.count, value: (`+expr+`)};
+			})()
+		`, &state))
+		if err == nil {
+			ok, err := satisfied(state.Value)
+			if err != nil {
+				return err
+			}
+			if ok {
+				log.Debug("Found", "what", what, "elapsed", time.Since(start))
+				return nil
+			}
+			err = waitForDOMChange(timeoutCtx, state.Changes)
+		}
+
+		if timeoutCtx.Err() != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("%v while waiting for %s", ctx.Err(), what)
+			}
+			return fmt.Errorf("timed out after %v waiting for %s", timeout, what)
+		}
+		if err != nil {
+			// The document was replaced while evaluating, try again in the new one
+			log.Debug("Evaluating again after page change", "what", what, "error", err)
+			select {
+			case <-timeoutCtx.Done():
+			case <-time.After(domRetryDelay):
+			}
+		}
+	}
+}
+
+// waitForDOMChange waits until the DOM has changed since seen changes were
+// counted, or domChangeFallback has passed
+func waitForDOMChange(ctx context.Context, seen int) error {
+	return chromedp.Run(ctx, chromedp.Evaluate(fmt.Sprintf(`
+		(function(seen, fallback) {
+			`+domChangesScript+`
+			const changes = window.__adpChanges;
+			if (changes.count !== seen) {
+				return true;
+			}
+			return new Promise(function(resolve) {
+				const timer = setTimeout(function() { resolve(false); }, fallback);
+				changes.listeners.push(function() {
+					clearTimeout(timer);
+					resolve(true);
+				});
+			});
+		})(%d, %d)
+	`, seen, domChangeFallback.Milliseconds()), nil, func(p *runtime.EvaluateParams) *runtime.EvaluateParams {
+		return p.WithAwaitPromise(true)
+	}))
+}
+
+// networkTracker counts the pending requests of a tab. Started together with
+// the tab, it also knows the requests already in flight when a wait begins.
+type networkTracker struct {
+	mu sync.Mutex
+	// pending maps requests to the document that started them
+	pending map[network.RequestID]pendingRequest
+	// activity is signalled whenever a request starts or ends
+	activity chan struct{}
+}
+
+// pendingRequest identifies the document a request belongs to
+type pendingRequest struct {
+	frame  cdp.FrameID
+	loader cdp.LoaderID
+}
+
+// networkTrackerKey stores the networkTracker of a tab in its context
+type networkTrackerKey struct{}
+
+func newNetworkTracker() *networkTracker {
+	return &networkTracker{
+		pending:  make(map[network.RequestID]pendingRequest),
+		activity: make(chan struct{}, 1),
+	}
+}
+
+// trackNetwork starts counting the pending requests of the tab of ctx and
+// returns a context carrying the tracker for runUntilNetworkIdle
+func trackNetwork(ctx context.Context) context.Context {
+	tracker := newNetworkTracker()
+	chromedp.ListenTarget(ctx, tracker.handle)
+	return context.WithValue(ctx, networkTrackerKey{}, tracker)
+}
+
+// handle updates the pending requests from a network or page event.
+// Long-lived connections never finish and are ignored, as are the requests of
+// a document a frame navigated away from.
+func (t *networkTracker) handle(ev any) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	switch ev := ev.(type) {
+	case *network.EventRequestWillBeSent:
+		if ev.Type == network.ResourceTypeEventSource || ev.Type == network.ResourceTypeWebSocket {
+			return
+		}
+		t.pending[ev.RequestID] = pendingRequest{ev.FrameID, ev.LoaderID}
+	case *network.EventLoadingFinished:
+		delete(t.pending, ev.RequestID)
+	case *network.EventLoadingFailed:
+		delete(t.pending, ev.RequestID)
+	case *page.EventFrameNavigated:
+		for id, request := range t.pending {
+			if request.frame == ev.Frame.ID && request.loader != ev.Frame.LoaderID {
+				delete(t.pending, id)
+			}
+		}
+	default:
+		return
+	}
+	select {
+	case t.activity <- struct{}{}:
+	default:
+	}
+}
+
+// count returns the number of pending requests
+func (t *networkTracker) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.pending)
+}
+
+// runUntilNetworkIdle runs actions and waits until no request of the page has
+// been pending for networkQuiet. Requests in flight before the call count if
+// the tab is tracked by trackNetwork, as tabs from newBrowser are.
+func runUntilNetworkIdle(ctx context.Context, timeout time.Duration, actions ...chromedp.Action) error {
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	// Without a tracker, only the requests started from now on are seen
+	tracker, ok := ctx.Value(networkTrackerKey{}).(*networkTracker)
+	if !ok {
+		listenCtx, stopListening := context.WithCancel(timeoutCtx)
+		defer stopListening()
+		tracker = newNetworkTracker()
+		chromedp.ListenTarget(listenCtx, tracker.handle)
+	}
+
+	if err := chromedp.Run(timeoutCtx, actions...); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	quiet := time.NewTimer(networkQuiet)
+	defer quiet.Stop()
+	for {
+		select {
+		case <-timeoutCtx.Done():
+			if ctx.Err() != nil {
+				return fmt.Errorf("%v while waiting for the network to become idle", ctx.Err())
+			}
+			return fmt.Errorf("timed out after %v waiting for the network to become idle (%d requests pending)", timeout, tracker.count())
+		case <-tracker.activity:
+			// Start over once nothing is pending anymore
+			quiet.Reset(networkQuiet)
+		case <-quiet.C:
+			if tracker.count() == 0 {
+				log.Debug("Network idle", "elapsed", time.Since(start))
+				return nil
+			}
+			quiet.Reset(networkQuiet)
+		}
+	}
+}
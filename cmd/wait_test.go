@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+)
+
+func TestNetworkTracker(t *testing.T) {
+	tracker := newNetworkTracker()
+	request := func(id, loader string, resourceType network.ResourceType) {
+		tracker.handle(&network.EventRequestWillBeSent{
+			RequestID: network.RequestID(id),
+			FrameID:   "main",
+			LoaderID:  cdp.LoaderID(loader),
+			Type:      resourceType,
+		})
+	}
+
+	request("1", "dashboard", network.ResourceTypeXHR)
+	request("2", "dashboard", network.ResourceTypeFetch)
+	request("3", "dashboard", network.ResourceTypeWebSocket)
+	if n := tracker.count(); n != 2 {
+		t.Fatalf("pending = %d, want 2 without the WebSocket", n)
+	}
+
+	tracker.handle(&network.EventLoadingFinished{RequestID: "1"})
+	if n := tracker.count(); n != 1 {
+		t.Fatalf("pending = %d after a request finished, want 1", n)
+	}
+
+	// Navigating away abandons the requests of the old document
+	request("4", "documents", network.ResourceTypeDocument)
+	tracker.handle(&page.EventFrameNavigated{Frame: &cdp.Frame{ID: "main", LoaderID: "documents"}})
+	if n := tracker.count(); n != 1 {
+		t.Fatalf("pending = %d after navigating, want only the new document's request", n)
+	}
+	tracker.handle(&network.EventLoadingFailed{RequestID: "4"})
+	if n := tracker.count(); n != 0 {
+		t.Errorf("pending = %d after the last request failed, want 0", n)
+	}
+}
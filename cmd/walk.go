@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// symlinkModes lists how symbolic links are handled while looking for PDFs
+var symlinkModes = []string{"skip", "files", "follow"}
+
+// walkOptions controls which files findPDFs returns
+type walkOptions struct {
+	// Recursive descends into subdirectories
+	Recursive bool
+	// Include and Exclude are glob patterns matched case-insensitively against
+	// the filename and the path relative to the root
+	Include []string
+	Exclude []string
+	// Symlinks is one of symlinkModes: skip all links, process linked files, or
+	// also descend into linked directories
+	Symlinks string
+}
+
+// defaultWalkOptions finds the PDFs directly within a directory
+var defaultWalkOptions = walkOptions{Include: []string{"*.pdf"}, Symlinks: "files"}
+
+// pdfFile is a PDF found below one of the directories passed to findPDFs
+type pdfFile struct {
+	// Root is the directory the file was found in or below
+	Root string
+	// Path is the location of the file, including Root
+	Path string
+}
+
+// addWalkFlags adds the flags selecting the PDFs a command reads
+func addWalkFlags(cmd *cobra.Command, config Config, paths *[]string, opts *walkOptions) {
+	cmd.Flags().StringSliceVar(paths, "path", []string{config.DefaultDir}, "Path to directory containing PDFs (repeatable)")
+	cmd.Flags().BoolVarP(&opts.Recursive, "recursive", "r", false, "Also read PDFs in subdirectories")
+	cmd.Flags().StringSliceVar(&opts.Include, "include", defaultWalkOptions.Include, "Glob patterns of files to read, matched case-insensitively")
+	cmd.Flags().StringSliceVar(&opts.Exclude, "exclude", nil, "Glob patterns of files and directories to skip")
+	cmd.Flags().StringVar(&opts.Symlinks, "symlinks", defaultWalkOptions.Symlinks, "Symlink handling: skip, files (read linked files), follow (also linked directories)")
+}
+
+// checkWalkFlags validates the flags added by addWalkFlags
+func checkWalkFlags(paths []string, opts walkOptions) error {
+	if !isSymlinkMode(opts.Symlinks) {
+		return fmt.Errorf("unsupported symlink mode %q (supported: %s)", opts.Symlinks, strings.Join(symlinkModes, ", "))
+	}
+	for _, path := range paths {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			return fmt.Errorf("directory %s does not exist", path)
+		}
+	}
+	return nil
+}
+
+func isSymlinkMode(mode string) bool {
+	for _, m := range symlinkModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesAny reports whether the filename or relative path matches one of the patterns
+func matchesAny(patterns []string, rel string) bool {
+	rel = strings.ToLower(filepath.ToSlash(rel))
+	name := filepath.Base(rel)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(filepath.ToSlash(pattern))
+		if ok, _ := filepath.Match(pattern, name); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(pattern, rel); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// findPDFs returns the files matching the options below the given directories,
+// in directory order, skipping decrypted copies of other PDFs. Files reachable
+// more than once, e.g. through symlinks or overlapping directories, are returned
+// once, preferring the file over a link to it.
+func findPDFs(roots []string, opts walkOptions) ([]pdfFile, error) {
+	for _, pattern := range append(append([]string{}, opts.Include...), opts.Exclude...) {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+
+	var files, links []pdfFile
+	seenFiles := make(map[string]bool)
+	seenDirs := make(map[string]bool)
+
+	var walk func(root, dir string) error
+	walk = func(root, dir string) error {
+		// Guard against symlink loops and directories reached twice
+		real, err := filepath.EvalSymlinks(dir)
+		if err != nil {
+			return err
+		}
+		if seenDirs[real] {
+			return nil
+		}
+		seenDirs[real] = true
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("failed to list %s: %v", dir, err)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(dir, entry.Name())
+			rel, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if matchesAny(opts.Exclude, rel) {
+				continue
+			}
+
+			mode := entry.Type()
+			isLink := mode&os.ModeSymlink != 0
+			if isLink {
+				if opts.Symlinks == "skip" {
+					continue
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					// Dangling link
+					continue
+				}
+				mode = info.Mode().Type()
+				if mode.IsDir() && opts.Symlinks != "follow" {
+					continue
+				}
+			}
+
+			switch {
+			case mode.IsDir():
+				if opts.Recursive {
+					if err := walk(root, path); err != nil {
+						return err
+					}
+				}
+			case mode.IsRegular():
+				if !matchesAny(opts.Include, rel) || isDecryptedCopy(rel) {
+					continue
+				}
+				if isLink {
+					links = append(links, pdfFile{Root: root, Path: path})
+					continue
+				}
+				real, err := filepath.EvalSymlinks(path)
+				if err != nil || seenFiles[real] {
+					continue
+				}
+				seenFiles[real] = true
+				files = append(files, pdfFile{Root: root, Path: path})
+			}
+		}
+		return nil
+	}
+
+	for _, root := range roots {
+		if err := walk(root, root); err != nil {
+			return nil, err
+		}
+	}
+
+	// Links only count if the file they point to wasn't found itself
+	for _, link := range links {
+		real, err := filepath.EvalSymlinks(link.Path)
+		if err != nil || seenFiles[real] {
+			continue
+		}
+		seenFiles[real] = true
+		files = append(files, link)
+	}
+	return files, nil
+}
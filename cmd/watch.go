@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchPDFs calls process whenever PDFs matching the walk options appear or
+// change below the directories, until ctx is cancelled. A file is only processed
+// once no events arrived for it during the debounce interval, so files still
+// being written are left alone. Events for the files written by the previous
+// run, starting with written, are ignored for one debounce interval after it,
+// so that a run's own renames don't trigger the next one.
+func watchPDFs(ctx context.Context, pdfPaths []string, opts walkOptions, debounce time.Duration, written []string, process func() ([]string, error)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	for _, pdfPath := range pdfPaths {
+		if err := addWatchDirs(watcher, pdfPath, opts); err != nil {
+			return err
+		}
+	}
+	log.Info("Watching for new PDFs", "path", pdfPaths, "debounce", debounce)
+
+	ticker := time.NewTicker(max(debounce/4, 100*time.Millisecond))
+	defer ticker.Stop()
+
+	// Last event per file not yet processed
+	pending := make(map[string]time.Time)
+	// Files written by the last run and when it ended
+	ownWrites := make(map[string]bool)
+	var lastRun time.Time
+	remember := func(paths []string) {
+		ownWrites = make(map[string]bool, len(paths))
+		for _, path := range paths {
+			ownWrites[filepath.Clean(path)] = true
+		}
+		lastRun = time.Now()
+	}
+	remember(written)
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info("Stopped watching")
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Write) {
+				continue
+			}
+
+			// Watch new subdirectories as well
+			if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+				if opts.Recursive {
+					if err := addWatchDirs(watcher, event.Name, opts); err != nil {
+						log.Warn("Failed to watch directory", "path", event.Name, "error", err)
+					}
+				}
+				continue
+			}
+
+			// The events of a run's own writes are delivered once it has finished
+			if ownWrites[filepath.Clean(event.Name)] && time.Since(lastRun) < debounce {
+				log.Debug("Ignoring own change", "filename", filepath.Base(event.Name), "op", event.Op)
+				continue
+			}
+
+			rel := relativeToRoot(pdfPaths, event.Name)
+			if matchesAny(opts.Include, rel) && !matchesAny(opts.Exclude, rel) && !isDecryptedCopy(rel) {
+				log.Debug("File changed", "filename", filepath.Base(event.Name), "op", event.Op)
+				pending[event.Name] = time.Now()
+			}
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Warn("Watch error", "error", err)
+
+		case <-ticker.C:
+			if len(pending) == 0 {
+				continue
+			}
+
+			// Wait until every changed file has been quiet for the debounce interval
+			settled := true
+			for _, last := range pending {
+				if time.Since(last) < debounce {
+					settled = false
+					break
+				}
+			}
+			if !settled {
+				continue
+			}
+
+			log.Info("Processing new PDFs", "count", len(pending))
+			pending = make(map[string]time.Time)
+			written, err := process()
+			if err != nil {
+				log.Error("Error processing PDFs", "error", err)
+			}
+			remember(written)
+		}
+	}
+}
+
+// addWatchDirs watches dir and, for recursive walks, its subdirectories
+func addWatchDirs(watcher *fsnotify.Watcher, dir string, opts walkOptions) error {
+	if !opts.Recursive {
+		return watcher.Add(dir)
+	}
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !entry.IsDir() {
+			return nil
+		}
+		if path != dir && matchesAny(opts.Exclude, entry.Name()) {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// relativeToRoot returns path relative to the first directory containing it
+func relativeToRoot(roots []string, path string) string {
+	for _, root := range roots {
+		if rel, err := filepath.Rel(root, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return rel
+		}
+	}
+	return filepath.Base(path)
+}
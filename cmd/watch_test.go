@@ -0,0 +1,47 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWatchPDFsIgnoresOwnWrites(t *testing.T) {
+	dir := t.TempDir()
+	debounce := 100 * time.Millisecond
+
+	// Each run renames the new PDF, like process does
+	var runs atomic.Int32
+	process := func() ([]string, error) {
+		runs.Add(1)
+		target := filepath.Join(dir, "2024-03_Verdienstabrechnung.pdf")
+		if err := os.Rename(filepath.Join(dir, "scan.pdf"), target); err != nil {
+			return nil, err
+		}
+		return []string{target}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- watchPDFs(ctx, []string{dir}, defaultWalkOptions, debounce, nil, process)
+	}()
+
+	// Give the watcher time to start before the PDF appears
+	time.Sleep(debounce)
+	if err := os.WriteFile(filepath.Join(dir, "scan.pdf"), []byte("%PDF"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * debounce)
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatalf("watchPDFs: %v", err)
+	}
+
+	if n := runs.Load(); n != 1 {
+		t.Errorf("process ran %d times, want once", n)
+	}
+}